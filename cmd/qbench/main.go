@@ -0,0 +1,162 @@
+// qbench 对QNet服务器做压测	统计吞吐、延迟与错误
+//
+//	qbench -addr 127.0.0.1:9000 -c 1000 -ramp 10s -d 60s -rate 20 -size 64-512 -echo
+//	qbench -serve :9000
+//
+// -echo 要求服务器原样返回每一帧	用于统计延迟	-rate 为0时收到回显后立即发送下一帧
+// -serve 启动一个回显服务器	方便在没有业务服务器时测试
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"wwt/ctrl"
+	"wwt/net/server"
+	"wwt/net/server/connection"
+)
+
+type Options struct {
+	Addr        string
+	Conns       int
+	Ramp        time.Duration
+	Duration    time.Duration
+	Rate        float64
+	MinSize     int
+	MaxSize     int
+	Echo        bool
+	EchoTimeout time.Duration
+	DialTimeout time.Duration
+}
+
+// 解析 "64" 或 "64-512"
+func parseSize(s string) (int, int, error) {
+	parts := strings.SplitN(s, "-", 2)
+	min, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, 0, err
+	}
+	max := min
+	if len(parts) == 2 {
+		if max, err = strconv.Atoi(parts[1]); err != nil {
+			return 0, 0, err
+		}
+	}
+	if min < 0 || max < min {
+		return 0, 0, errors.New("invalid size range " + s)
+	}
+	return min, max, nil
+}
+
+func main() {
+	opts := &Options{}
+	flag.StringVar(&opts.Addr, "addr", "127.0.0.1:9000", "server address")
+	flag.IntVar(&opts.Conns, "c", 10, "number of concurrent connections")
+	flag.DurationVar(&opts.Ramp, "ramp", 0, "time to open all connections")
+	flag.DurationVar(&opts.Duration, "d", 10*time.Second, "test duration after ramp-up")
+	flag.Float64Var(&opts.Rate, "rate", 0, "frames per second per connection (0 = unthrottled)")
+	size := flag.String("size", "64", "payload size in bytes, or a min-max range")
+	flag.BoolVar(&opts.Echo, "echo", false, "expect the server to echo every frame and measure latency")
+	flag.DurationVar(&opts.EchoTimeout, "echo-timeout", 5*time.Second, "how long to wait for an echo")
+	flag.DurationVar(&opts.DialTimeout, "dial-timeout", 5*time.Second, "dial timeout")
+	serve := flag.String("serve", "", "run an echo server on the address instead of a benchmark")
+	flag.Parse()
+
+	if *serve != "" {
+		runEchoServer(*serve)
+		return
+	}
+
+	var err error
+	if opts.MinSize, opts.MaxSize, err = parseSize(*size); err != nil {
+		fatal(err)
+	}
+	if opts.Echo && opts.MinSize < ECHO_HEADER {
+		fatal(fmt.Errorf("payload size must be at least %d bytes with -echo", ECHO_HEADER))
+	}
+	if opts.Conns <= 0 {
+		fatal(errors.New("-c must be positive"))
+	}
+	run(opts)
+}
+
+func run(opts *Options) {
+	fmt.Printf("qbench: %d connections to %s, ramp %s, duration %s\n", opts.Conns, opts.Addr, opts.Ramp, opts.Duration)
+	stop := make(chan struct{})
+	workers := make([]*worker, opts.Conns)
+	wg := sync.WaitGroup{}
+	start := time.Now()
+
+	//	在ramp时间内均匀建立连接
+	var step time.Duration
+	if opts.Conns > 1 {
+		step = opts.Ramp / time.Duration(opts.Conns-1)
+	}
+	for i := 0; i < opts.Conns; i++ {
+		w := newWorker(opts)
+		workers[i] = w
+		wg.Add(1)
+		go func(delay time.Duration) {
+			defer wg.Done()
+			select {
+			case <-stop:
+				return
+			case <-time.After(delay):
+			}
+			if err := w.dial(context.Background()); err != nil {
+				return
+			}
+			w.run(stop)
+		}(step * time.Duration(i))
+	}
+
+	select {
+	case <-time.After(opts.Ramp + opts.Duration):
+	case sig := <-ctrl.GlobalExitChan():
+		fmt.Printf("qbench: receive %s, stopping\n", sig)
+	}
+	close(stop)
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	finished := sync.WaitGroup{}
+	for _, w := range workers {
+		finished.Add(1)
+		go func(w *worker) {
+			defer finished.Done()
+			w.finish()
+		}(w)
+	}
+	finished.Wait()
+
+	total := &Stats{}
+	for _, w := range workers {
+		total.merge(&w.stats)
+	}
+	total.Report(os.Stdout, elapsed, opts.Echo)
+}
+
+func runEchoServer(address string) {
+	s, err := server.Listen(address, nil)
+	if err != nil {
+		fatal(err)
+	}
+	s.SetProcesser(func(token connection.TokenHandler, _ int, b []byte) {
+		token.Write(b)
+	})
+	s.AsyncListen()
+	fmt.Printf("qbench: echo server on %s\n", address)
+	<-ctrl.GlobalExitChan()
+	s.Close()
+}
+
+func fatal(err error) {
+	fmt.Fprintln(os.Stderr, "qbench:", err)
+	os.Exit(1)
+}
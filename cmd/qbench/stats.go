@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+)
+
+// 压测统计	每个连接各自累计	结束时合并
+type Stats struct {
+	mu sync.Mutex
+
+	Dialed   int64
+	DialFail int64
+	Lost     int64 //	压测结束前断开的连接
+
+	Sent      int64
+	SentBytes int64
+	Recv      int64
+	RecvBytes int64
+	Mismatch  int64 //	回显内容与发送的不一致
+	Timeouts  int64 //	结束时仍未收到回显的帧
+
+	latencies []time.Duration
+}
+
+func (this *Stats) merge(o *Stats) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	this.Dialed += o.Dialed
+	this.DialFail += o.DialFail
+	this.Lost += o.Lost
+	this.Sent += o.Sent
+	this.SentBytes += o.SentBytes
+	this.Recv += o.Recv
+	this.RecvBytes += o.RecvBytes
+	this.Mismatch += o.Mismatch
+	this.Timeouts += o.Timeouts
+	this.latencies = append(this.latencies, o.latencies...)
+}
+
+// 已排序的延迟中取百分位
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	idx := int(float64(len(sorted))*p+0.5) - 1
+	if idx < 0 {
+		idx = 0
+	}
+	if idx >= len(sorted) {
+		idx = len(sorted) - 1
+	}
+	return sorted[idx]
+}
+
+func (this *Stats) Report(w io.Writer, elapsed time.Duration, echo bool) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	secs := elapsed.Seconds()
+	if secs <= 0 {
+		secs = 1
+	}
+	fmt.Fprintf(w, "duration     %s\n", elapsed.Round(time.Millisecond))
+	fmt.Fprintf(w, "connections  %d ok, %d failed, %d lost\n", this.Dialed, this.DialFail, this.Lost)
+	fmt.Fprintf(w, "sent         %d frames, %d bytes (%.1f frames/s, %.2f MB/s)\n",
+		this.Sent, this.SentBytes, float64(this.Sent)/secs, float64(this.SentBytes)/secs/1e6)
+	fmt.Fprintf(w, "received     %d frames, %d bytes (%.1f frames/s, %.2f MB/s)\n",
+		this.Recv, this.RecvBytes, float64(this.Recv)/secs, float64(this.RecvBytes)/secs/1e6)
+	if !echo {
+		return
+	}
+	fmt.Fprintf(w, "errors       %d mismatched, %d unanswered\n", this.Mismatch, this.Timeouts)
+	sort.Slice(this.latencies, func(i, j int) bool {
+		return this.latencies[i] < this.latencies[j]
+	})
+	if len(this.latencies) == 0 {
+		fmt.Fprintln(w, "latency      no samples")
+		return
+	}
+	fmt.Fprintf(w, "latency      p50 %s  p90 %s  p99 %s  max %s\n",
+		percentile(this.latencies, 0.50), percentile(this.latencies, 0.90),
+		percentile(this.latencies, 0.99), this.latencies[len(this.latencies)-1])
+}
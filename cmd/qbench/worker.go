@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"math/rand"
+	"sync"
+	"time"
+	"wwt/net/client"
+)
+
+// 回显模式下的帧头	序号 + 发送时间(纳秒)
+const ECHO_HEADER = 16
+
+type worker struct {
+	opts  *Options
+	stats Stats
+
+	client *client.QClient
+	lost   chan struct{}
+
+	mu       sync.Mutex
+	inflight map[uint64][]byte //	等待回显的帧
+	seq      uint64
+	answered chan struct{} //	闭环模式下收到回显的通知
+}
+
+func newWorker(opts *Options) *worker {
+	return &worker{
+		opts:     opts,
+		lost:     make(chan struct{}),
+		inflight: make(map[uint64][]byte),
+		answered: make(chan struct{}, 1),
+	}
+}
+
+func (this *worker) dial(ctx context.Context) error {
+	c := &client.QClient{}
+	c.SetDialOptions(client.DialOptions{Timeout: this.opts.DialTimeout})
+	if err := c.DialContext(ctx, this.opts.Addr, this.onRead, this.onClose); err != nil {
+		this.stats.DialFail++
+		return err
+	}
+	this.client = c
+	this.stats.Dialed++
+	return nil
+}
+
+func (this *worker) onClose(client.ClientHandler) {
+	close(this.lost)
+}
+
+func (this *worker) onRead(_ client.ClientHandler, _ int, b []byte) {
+	now := time.Now()
+	this.stats.mu.Lock()
+	this.stats.Recv++
+	this.stats.RecvBytes += int64(len(b))
+	this.stats.mu.Unlock()
+	if !this.opts.Echo {
+		return
+	}
+	if len(b) < ECHO_HEADER {
+		this.stats.mu.Lock()
+		this.stats.Mismatch++
+		this.stats.mu.Unlock()
+		return
+	}
+	seq := binary.BigEndian.Uint64(b)
+	this.mu.Lock()
+	sent, ok := this.inflight[seq]
+	delete(this.inflight, seq)
+	this.mu.Unlock()
+
+	this.stats.mu.Lock()
+	if !ok || !bytes.Equal(sent, b) {
+		this.stats.Mismatch++
+	} else {
+		ts := int64(binary.BigEndian.Uint64(b[8:]))
+		this.stats.latencies = append(this.stats.latencies, now.Sub(time.Unix(0, ts)))
+	}
+	this.stats.mu.Unlock()
+
+	select {
+	case this.answered <- struct{}{}:
+	default:
+	}
+}
+
+func (this *worker) payload() []byte {
+	size := this.opts.MinSize
+	if this.opts.MaxSize > this.opts.MinSize {
+		size += rand.Intn(this.opts.MaxSize - this.opts.MinSize + 1)
+	}
+	b := make([]byte, size)
+	rand.Read(b)
+	if this.opts.Echo {
+		this.mu.Lock()
+		this.seq++
+		binary.BigEndian.PutUint64(b, this.seq)
+		binary.BigEndian.PutUint64(b[8:], uint64(time.Now().UnixNano()))
+		this.inflight[this.seq] = b
+		this.mu.Unlock()
+	}
+	return b
+}
+
+func (this *worker) send() {
+	b := this.payload()
+	this.client.Write(b)
+	this.stats.mu.Lock()
+	this.stats.Sent++
+	this.stats.SentBytes += int64(len(b))
+	this.stats.mu.Unlock()
+}
+
+// 按速率发送直到stop关闭	rate<=0 时回显模式下收到应答后立即发送下一帧	否则不限速
+func (this *worker) run(stop <-chan struct{}) {
+	var tick <-chan time.Time
+	if this.opts.Rate > 0 {
+		t := time.NewTicker(time.Duration(float64(time.Second) / this.opts.Rate))
+		defer t.Stop()
+		tick = t.C
+	}
+	for {
+		select {
+		case <-stop:
+			return
+		case <-this.lost:
+			this.stats.mu.Lock()
+			this.stats.Lost++
+			this.stats.mu.Unlock()
+			return
+		default:
+		}
+		switch {
+		case tick != nil:
+			select {
+			case <-stop:
+				return
+			case <-this.lost:
+				continue
+			case <-tick:
+			}
+		case this.opts.Echo && this.stats.Sent > 0:
+			select {
+			case <-stop:
+				return
+			case <-this.lost:
+				continue
+			case <-this.answered:
+			case <-time.After(this.opts.EchoTimeout):
+				//	回显丢失	不让闭环卡死
+			}
+		}
+		this.send()
+	}
+}
+
+// 等待剩余的回显	然后关闭连接
+func (this *worker) finish() {
+	if this.client == nil {
+		return
+	}
+	if this.opts.Echo {
+		deadline := time.Now().Add(this.opts.EchoTimeout)
+		for time.Now().Before(deadline) {
+			this.mu.Lock()
+			n := len(this.inflight)
+			this.mu.Unlock()
+			if n == 0 {
+				break
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		this.mu.Lock()
+		this.stats.mu.Lock()
+		this.stats.Timeouts += int64(len(this.inflight))
+		this.stats.mu.Unlock()
+		this.mu.Unlock()
+	}
+	select {
+	case <-this.lost:
+	default:
+		this.client.Close()
+	}
+}
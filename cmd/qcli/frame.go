@@ -0,0 +1,181 @@
+package main
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+	"wwt/util"
+)
+
+// 按空白切分	双引号内的内容作为一个整体	支持Go的转义写法
+func tokenize(line string) ([]string, error) {
+	res := make([]string, 0)
+	i := 0
+	for i < len(line) {
+		for i < len(line) && unicode.IsSpace(rune(line[i])) {
+			i++
+		}
+		if i >= len(line) {
+			break
+		}
+		if line[i] == '"' {
+			j := i + 1
+			for j < len(line) && line[j] != '"' {
+				if line[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			if j >= len(line) {
+				return nil, errors.New("unterminated string")
+			}
+			s, err := strconv.Unquote(line[i : j+1])
+			if err != nil {
+				return nil, err
+			}
+			res = append(res, s)
+			i = j + 1
+			continue
+		}
+		j := i
+		for j < len(line) && !unicode.IsSpace(rune(line[j])) {
+			j++
+		}
+		res = append(res, line[i:j])
+		i = j
+	}
+	return res, nil
+}
+
+// 解析十六进制	允许空格与0x前缀
+func parseHex(args []string) ([]byte, error) {
+	s := strings.Join(args, "")
+	s = strings.Replace(s, "0x", "", -1)
+	return hex.DecodeString(s)
+}
+
+// 按类型序列写入StreamBuffer	例如 int 1 float32 2.5 line "hello" byte 7 hex 0aff text abc
+func buildTyped(args []string) ([]byte, error) {
+	stream := util.NewStreamBuffer()
+	for i := 0; i < len(args); i += 2 {
+		if i+1 >= len(args) {
+			return nil, fmt.Errorf("%s: missing value", args[i])
+		}
+		kind, v := args[i], args[i+1]
+		switch kind {
+		case "int":
+			n, err := strconv.ParseInt(v, 0, 32)
+			if err != nil {
+				return nil, err
+			}
+			stream.WriteInt(int(n))
+		case "byte":
+			n, err := strconv.ParseUint(v, 0, 8)
+			if err != nil {
+				return nil, err
+			}
+			stream.WriteByte(byte(n))
+		case "float32":
+			f, err := strconv.ParseFloat(v, 32)
+			if err != nil {
+				return nil, err
+			}
+			stream.WriteFloat32(float32(f))
+		case "float64":
+			f, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				return nil, err
+			}
+			stream.WriteFloat64(f)
+		case "line":
+			stream.WriteLine(v)
+		case "text":
+			stream.Append([]byte(v))
+		case "hex":
+			b, err := hex.DecodeString(strings.TrimPrefix(v, "0x"))
+			if err != nil {
+				return nil, err
+			}
+			stream.Append(b)
+		default:
+			return nil, fmt.Errorf("unknown type %s", kind)
+		}
+	}
+	return stream.Bytes(), nil
+}
+
+var layoutTypes = map[string]bool{"int": true, "byte": true, "float32": true, "float64": true, "line": true}
+
+func parseLayout(args []string) ([]string, error) {
+	for _, t := range args {
+		if !layoutTypes[t] {
+			return nil, fmt.Errorf("unknown type %s", t)
+		}
+	}
+	return args, nil
+}
+
+// 按类型序列解析收到的帧	帧长度不够时返回错误
+func decodeTyped(layout []string, b []byte) (res string, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = errors.New("frame too short for layout")
+		}
+	}()
+	stream := util.NewStreamBuffer()
+	stream.Append(b)
+	parts := make([]string, 0, len(layout))
+	for _, t := range layout {
+		switch t {
+		case "int":
+			parts = append(parts, "int "+strconv.Itoa(stream.ReadInt()))
+		case "byte":
+			parts = append(parts, "byte "+strconv.Itoa(int(stream.ReadByte())))
+		case "float32":
+			parts = append(parts, "float32 "+strconv.FormatFloat(float64(stream.ReadFloat32()), 'g', -1, 32))
+		case "float64":
+			parts = append(parts, "float64 "+strconv.FormatFloat(stream.ReadFloat64(), 'g', -1, 64))
+		case "line":
+			parts = append(parts, "line "+strconv.Quote(stream.ReadLine()))
+		}
+	}
+	if rest := stream.Len(); rest > 0 {
+		parts = append(parts, fmt.Sprintf("(+%d bytes)", rest))
+	}
+	return strings.Join(parts, " "), nil
+}
+
+// 类似 hexdump -C 的输出
+func dump(b []byte) string {
+	sb := strings.Builder{}
+	for off := 0; off < len(b); off += 16 {
+		end := off + 16
+		if end > len(b) {
+			end = len(b)
+		}
+		fmt.Fprintf(&sb, "  %04x  ", off)
+		for i := off; i < off+16; i++ {
+			if i < end {
+				fmt.Fprintf(&sb, "%02x ", b[i])
+			} else {
+				sb.WriteString("   ")
+			}
+			if i == off+7 {
+				sb.WriteByte(' ')
+			}
+		}
+		sb.WriteString(" |")
+		for _, c := range b[off:end] {
+			if c >= 0x20 && c < 0x7f {
+				sb.WriteByte(c)
+			} else {
+				sb.WriteByte('.')
+			}
+		}
+		sb.WriteString("|\n")
+	}
+	return sb.String()
+}
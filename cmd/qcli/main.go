@@ -0,0 +1,211 @@
+// qcli 连接QNet服务器的交互式客户端
+//
+//	qcli -addr 127.0.0.1:9000
+//	qcli -addr 127.0.0.1:9000 -script login.qcli -wait 2s
+//
+// 输入 help 查看命令	脚本文件每行一条命令	以#开头的行为注释
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+	"wwt/net/client"
+	"wwt/net/peer"
+)
+
+const HELP = `commands:
+  hex <bytes>            send raw bytes, e.g. hex 01 02 ff
+  text <string>          send the rest of the line as-is
+  send <type value>...   compose a frame with StreamBuffer, types:
+                         int, byte, float32, float64, line, text, hex
+                         e.g. send int 1001 line "player one" float32 2.5
+  decode <type>...       decode incoming frames with the given layout
+  decode off             show incoming frames as hex only
+  sleep <duration>       pause, e.g. sleep 500ms
+  help                   show this message
+  quit                   close the connection and exit`
+
+type session struct {
+	out    io.Writer
+	mu     sync.Mutex
+	client *client.QClient
+	layout []string
+	closed chan struct{}
+}
+
+func (this *session) printf(format string, args ...interface{}) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	fmt.Fprintf(this.out, "[%s] ", time.Now().Format("15:04:05.000"))
+	fmt.Fprintf(this.out, format, args...)
+}
+
+func (this *session) onRead(_ client.ClientHandler, _ int, b []byte) {
+	this.mu.Lock()
+	layout := this.layout
+	this.mu.Unlock()
+	text := fmt.Sprintf("<- %d bytes\n%s", len(b), dump(b))
+	if len(layout) > 0 {
+		if v, err := decodeTyped(layout, b); err != nil {
+			text += "  decode: " + err.Error() + "\n"
+		} else {
+			text += "  " + v + "\n"
+		}
+	}
+	this.printf("%s", text)
+}
+
+func (this *session) onClose(client.ClientHandler) {
+	this.printf("connection closed\n")
+	close(this.closed)
+}
+
+func (this *session) send(b []byte) {
+	if this.client.IsClosed() {
+		this.printf("not connected\n")
+		return
+	}
+	this.client.Write(b)
+	this.printf("-> %d bytes\n%s", len(b), dump(b))
+}
+
+// 执行一条命令	返回false表示退出
+func (this *session) exec(line string) bool {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return true
+	}
+	cmd, rest := line, ""
+	if i := strings.IndexAny(line, " \t"); i >= 0 {
+		cmd, rest = line[:i], strings.TrimSpace(line[i+1:])
+	}
+	args, err := tokenize(rest)
+	if err != nil {
+		this.printf("error: %s\n", err.Error())
+		return true
+	}
+
+	switch cmd {
+	case "hex":
+		b, err := parseHex(args)
+		if err != nil {
+			this.printf("error: %s\n", err.Error())
+			return true
+		}
+		this.send(b)
+	case "text":
+		this.send([]byte(rest))
+	case "send":
+		b, err := buildTyped(args)
+		if err != nil {
+			this.printf("error: %s\n", err.Error())
+			return true
+		}
+		this.send(b)
+	case "decode":
+		var layout []string
+		if len(args) != 1 || args[0] != "off" {
+			if layout, err = parseLayout(args); err != nil {
+				this.printf("error: %s\n", err.Error())
+				return true
+			}
+		}
+		this.mu.Lock()
+		this.layout = layout
+		this.mu.Unlock()
+	case "sleep":
+		d, err := time.ParseDuration(rest)
+		if err != nil {
+			this.printf("error: %s\n", err.Error())
+			return true
+		}
+		time.Sleep(d)
+	case "help":
+		fmt.Fprintln(this.out, HELP)
+	case "quit", "exit":
+		return false
+	default:
+		this.printf("unknown command %s, type help\n", cmd)
+	}
+	return true
+}
+
+func codecByName(name string) (peer.FrameCodec, error) {
+	switch name {
+	case "", "int32be":
+		return peer.NewInt32BECodec(), nil
+	case "uint16le":
+		return peer.NewUint16LECodec(), nil
+	case "varint":
+		return peer.NewVarintCodec(), nil
+	case "line":
+		return peer.NewLineCodec(), nil
+	}
+	return nil, fmt.Errorf("unknown codec %s", name)
+}
+
+func main() {
+	addr := flag.String("addr", "127.0.0.1:9000", "server address")
+	codec := flag.String("codec", "int32be", "frame codec: int32be, uint16le, varint or line")
+	script := flag.String("script", "", "run commands from a file and exit")
+	wait := flag.Duration("wait", time.Second, "time to wait for responses after a script finishes")
+	timeout := flag.Duration("timeout", 5*time.Second, "dial timeout")
+	flag.Parse()
+
+	c, err := codecByName(*codec)
+	if err != nil {
+		fatal(err)
+	}
+	s := &session{out: os.Stdout, client: &client.QClient{}, closed: make(chan struct{})}
+	s.client.SetDialOptions(client.DialOptions{Timeout: *timeout})
+	s.client.SetFrameCodec(c)
+	if err := s.client.Dial(*addr, s.onRead, s.onClose); err != nil {
+		fatal(err)
+	}
+	s.printf("connected to %s\n", *addr)
+
+	if *script != "" {
+		f, err := os.Open(*script)
+		if err != nil {
+			fatal(err)
+		}
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			line := scanner.Text()
+			if t := strings.TrimSpace(line); t != "" && !strings.HasPrefix(t, "#") {
+				s.printf("> %s\n", t)
+			}
+			if !s.exec(line) {
+				break
+			}
+		}
+		f.Close()
+		select {
+		case <-time.After(*wait):
+		case <-s.closed:
+		}
+		s.client.Close()
+		return
+	}
+
+	fmt.Println("type help for commands")
+	scanner := bufio.NewScanner(os.Stdin)
+	for {
+		fmt.Print("> ")
+		if !scanner.Scan() || !s.exec(scanner.Text()) {
+			break
+		}
+	}
+	s.client.Close()
+}
+
+func fatal(err error) {
+	fmt.Fprintln(os.Stderr, "qcli:", err)
+	os.Exit(1)
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"time"
+	"wwt/discovery"
+	"wwt/util/breaker"
+)
+
+// 配置中的时长	可以写成 "5s" 这样的字符串或以秒为单位的数字
+type Duration time.Duration
+
+func (this *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err == nil {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+		*this = Duration(d)
+		return nil
+	}
+	f, err := strconv.ParseFloat(string(b), 64)
+	if err != nil {
+		return fmt.Errorf("invalid duration %s", string(b))
+	}
+	*this = Duration(f * float64(time.Second))
+	return nil
+}
+
+func (this Duration) D() time.Duration {
+	return time.Duration(this)
+}
+
+// 监听TLS	cert与key为PEM格式的证书与私钥文件路径
+// 例如 {"address": ":9443", "pool": "game", "tls": {"cert": "server.crt", "key": "server.key"}}
+type TLSConfig struct {
+	Cert string `json:"cert"`
+	Key  string `json:"key"`
+}
+
+// 监听地址	收到的连接转发到Pool指定的后端池
+type ListenConfig struct {
+	Address string     `json:"address"`
+	Pool    string     `json:"pool"`
+	TLS     *TLSConfig `json:"tls"`
+}
+
+// 准入限制	可以热加载
+type LimitConfig struct {
+	MaxConnPerIP int      `json:"max_conn_per_ip"`
+	AcceptRate   float64  `json:"accept_rate"`
+	AcceptBurst  int      `json:"accept_burst"`
+	Allow        []string `json:"allow"`
+	Deny         []string `json:"deny"`
+}
+
+type BreakerConfig struct {
+	FailureThreshold int      `json:"failure_threshold"`
+	Cooldown         Duration `json:"cooldown"`
+	ErrorRate        float64  `json:"error_rate"`
+	TimeoutRate      float64  `json:"timeout_rate"`
+	MinRequests      int      `json:"min_requests"`
+}
+
+// 后端池	Backends与File二选一	File为后端列表文件	变化时自动加载
+type PoolConfig struct {
+	Backends      []discovery.Backend `json:"backends"`
+	File          string              `json:"file"`
+	Idle          int                 `json:"idle"`
+	DialTimeout   Duration            `json:"dial_timeout"`
+	Sticky        string              `json:"sticky"` //	"" 或 "ip"	按客户端IP粘性绑定后端
+	Failover      bool                `json:"failover"`
+	RetryInterval Duration            `json:"retry_interval"`
+	Breaker       BreakerConfig       `json:"breaker"`
+}
+
+type LogConfig struct {
+	File   string `json:"file"`
+	Prefix string `json:"prefix"`
+}
+
+type Config struct {
+	Listen          []ListenConfig        `json:"listen"`
+	Pools           map[string]PoolConfig `json:"pools"`
+	Limits          LimitConfig           `json:"limits"`
+	Heartbeat       Duration              `json:"heartbeat"` //	<=0 表示不发送心跳
+	ShutdownTimeout Duration              `json:"shutdown_timeout"`
+	Log             LogConfig             `json:"log"`
+}
+
+const (
+	DEFAULT_SHUTDOWN_TIMEOUT = 30 * time.Second
+	DEFAULT_IDLE             = 4
+)
+
+func LoadConfig(path string) (*Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	conf := &Config{}
+	if err := json.Unmarshal(data, conf); err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err.Error())
+	}
+	if err := conf.validate(); err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err.Error())
+	}
+	return conf, nil
+}
+
+func (this *Config) validate() error {
+	if len(this.Listen) == 0 {
+		return errors.New("no listen address")
+	}
+	if len(this.Pools) == 0 {
+		return errors.New("no backend pool")
+	}
+	for i := range this.Listen {
+		l := &this.Listen[i]
+		if l.Address == "" {
+			return errors.New("listen address is empty")
+		}
+		if l.Pool == "" && len(this.Pools) == 1 {
+			for name := range this.Pools {
+				l.Pool = name
+			}
+		}
+		if _, ok := this.Pools[l.Pool]; !ok {
+			return fmt.Errorf("listen %s: unknown pool %q", l.Address, l.Pool)
+		}
+		if l.TLS != nil && (l.TLS.Cert == "" || l.TLS.Key == "") {
+			return fmt.Errorf("listen %s: tls needs cert and key", l.Address)
+		}
+	}
+	for name, p := range this.Pools {
+		if len(p.Backends) == 0 && p.File == "" {
+			return fmt.Errorf("pool %s: no backends", name)
+		}
+		if len(p.Backends) > 0 && p.File != "" {
+			return fmt.Errorf("pool %s: backends and file are exclusive", name)
+		}
+		if p.Sticky != "" && p.Sticky != "ip" {
+			return fmt.Errorf("pool %s: unknown sticky key %q", name, p.Sticky)
+		}
+		if p.Idle <= 0 {
+			p.Idle = DEFAULT_IDLE
+		}
+		this.Pools[name] = p
+	}
+	if this.ShutdownTimeout <= 0 {
+		this.ShutdownTimeout = Duration(DEFAULT_SHUTDOWN_TIMEOUT)
+	}
+	return nil
+}
+
+func (this *TLSConfig) load() (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(this.Cert, this.Key)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{Certificates: []tls.Certificate{cert}}, nil
+}
+
+func (this *BreakerConfig) options() breaker.Options {
+	return breaker.Options{
+		FailureThreshold: this.FailureThreshold,
+		Cooldown:         this.Cooldown.D(),
+		ErrorRate:        this.ErrorRate,
+		TimeoutRate:      this.TimeoutRate,
+		MinRequests:      this.MinRequests,
+	}
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"log"
+	"net"
+	"os"
+	"reflect"
+	"sync"
+	"time"
+	"wwt/discovery"
+	"wwt/net/client"
+	"wwt/net/server"
+	"wwt/net/server/connection"
+	"wwt/proxy"
+	"wwt/util"
+)
+
+// 后端池	一个池对应一个代理
+type pool struct {
+	name   string
+	conf   PoolConfig
+	proxy  proxy.ProxyHandle
+	static *discovery.StaticProvider //	使用File时为nil
+	syncer *discovery.Syncer
+}
+
+func newPool(name string, conf PoolConfig) (*pool, error) {
+	p := &pool{name: name, conf: conf, proxy: proxy.NewProxy()}
+	p.proxy.SetDialOptions(client.DialOptions{Timeout: conf.DialTimeout.D()})
+	p.proxy.SetBreaker(conf.Breaker.options())
+	failover := proxy.FailoverOptions{
+		Rebind:        conf.Failover,
+		RetryInterval: conf.RetryInterval.D(),
+		OnBackendLost: func(addr string, principals []connection.TokenHandler) {
+			log.Printf("QGate: pool %s lost backend %s, %d sessions affected.\n", name, addr, len(principals))
+		},
+	}
+	if conf.Sticky == "ip" {
+		failover.StickyKey = stickyIP
+	}
+	p.proxy.SetFailover(failover)
+	p.proxy.SetResponseCallback(func(token connection.TokenHandler, _ int, b []byte) {
+		token.Write(b)
+	})
+
+	var provider discovery.Provider
+	if conf.File != "" {
+		provider = discovery.NewFileProvider(conf.File, 0)
+	} else {
+		p.static = discovery.NewStatic(conf.Backends)
+		provider = p.static
+	}
+	syncer, err := discovery.Sync(provider, discovery.ProxyTarget(p.proxy, conf.Idle))
+	if err != nil {
+		p.proxy.Close()
+		return nil, err
+	}
+	p.syncer = syncer
+	return p, nil
+}
+
+func stickyIP(principal connection.TokenHandler) string {
+	addr := principal.RemoteAddr()
+	if addr == nil {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	return host
+}
+
+// 热加载后端列表	File与其他参数的变化需要重启
+func (this *pool) reload(conf PoolConfig) {
+	if this.static != nil && conf.File == "" {
+		this.static.Update(conf.Backends)
+		this.conf.Backends = conf.Backends
+	}
+	next, old := conf, this.conf
+	next.Backends, old.Backends = nil, nil
+	if !reflect.DeepEqual(next, old) {
+		log.Printf("QGate: pool %s settings changed, restart to apply.\n", this.name)
+	}
+}
+
+func (this *pool) close() {
+	this.syncer.Close()
+	this.proxy.Close()
+}
+
+type gateServer struct {
+	conf   ListenConfig
+	server server.QServerHandle
+}
+
+type Gate struct {
+	path string
+
+	mu      sync.Mutex
+	conf    *Config
+	pools   map[string]*pool
+	servers []*gateServer
+	limits  LimitConfig //	已下发的准入限制	热加载时先撤销
+	logfile *os.File
+}
+
+func NewGate(path string) (*Gate, error) {
+	conf, err := LoadConfig(path)
+	if err != nil {
+		return nil, err
+	}
+	return &Gate{path: path, conf: conf, pools: make(map[string]*pool)}, nil
+}
+
+func (this *Gate) Start() error {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	if err := this.setupLog(this.conf.Log); err != nil {
+		return err
+	}
+	for name, conf := range this.conf.Pools {
+		p, err := newPool(name, conf)
+		if err != nil {
+			this.closeAll()
+			return err
+		}
+		this.pools[name] = p
+	}
+	for _, conf := range this.conf.Listen {
+		var config *tls.Config
+		if conf.TLS != nil {
+			c, err := conf.TLS.load()
+			if err != nil {
+				this.closeAll()
+				return err
+			}
+			config = c
+		}
+		s, err := server.Listen(conf.Address, config)
+		if err != nil {
+			this.closeAll()
+			return err
+		}
+		this.servers = append(this.servers, &gateServer{conf, s})
+		this.wire(s, this.pools[conf.Pool])
+	}
+	this.applyLimits(this.conf.Limits)
+	for _, gs := range this.servers {
+		gs.server.AsyncListen()
+		if this.conf.Heartbeat > 0 {
+			gs.server.SetHeartbeatInterval(this.conf.Heartbeat.D())
+			gs.server.HeartbeatStart()
+		}
+		log.Printf("QGate: listen %s -> pool %s.\n", gs.conf.Address, gs.conf.Pool)
+	}
+	return nil
+}
+
+// 把服务器收到的帧转发给后端池	连接关闭时回收后端连接
+func (this *Gate) wire(s server.QServerHandle, p *pool) {
+	s.SetProcesser(func(token connection.TokenHandler, _ int, b []byte) {
+		stream := util.NewStreamBuffer()
+		stream.Append(b)
+		p.proxy.ProcessProxyMessage(token, stream)
+	})
+	s.SetCloseHandler(func(token connection.TokenHandler) {
+		p.proxy.RecyclingConnection(token)
+	})
+}
+
+// 调用者持有锁
+func (this *Gate) applyLimits(limits LimitConfig) {
+	for _, gs := range this.servers {
+		f := gs.server.Filter()
+		for _, cidr := range this.limits.Allow {
+			f.RemoveAllow(cidr)
+		}
+		for _, cidr := range this.limits.Deny {
+			f.RemoveDeny(cidr)
+		}
+		for _, cidr := range limits.Allow {
+			if err := f.Allow(cidr); err != nil {
+				log.Printf("QGate: invalid allow %s. %s.\n", cidr, err.Error())
+			}
+		}
+		for _, cidr := range limits.Deny {
+			if err := f.Deny(cidr); err != nil {
+				log.Printf("QGate: invalid deny %s. %s.\n", cidr, err.Error())
+			}
+		}
+		f.SetMaxConnPerIP(limits.MaxConnPerIP)
+		f.SetAcceptRate(limits.AcceptRate, limits.AcceptBurst)
+	}
+	this.limits = limits
+}
+
+// 调用者持有锁
+func (this *Gate) setupLog(conf LogConfig) error {
+	log.SetPrefix(conf.Prefix)
+	if conf.File == "" {
+		log.SetOutput(os.Stderr)
+	} else {
+		//	每次加载都重新打开	配合外部的日志轮转
+		f, err := os.OpenFile(conf.File, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return err
+		}
+		log.SetOutput(f)
+		if this.logfile != nil {
+			this.logfile.Close()
+		}
+		this.logfile = f
+		return nil
+	}
+	if this.logfile != nil {
+		this.logfile.Close()
+		this.logfile = nil
+	}
+	return nil
+}
+
+// 重新读取配置	准入限制、日志与后端列表即时生效	监听地址与TLS等的变化需要重启
+func (this *Gate) Reload() error {
+	conf, err := LoadConfig(this.path)
+	if err != nil {
+		return err
+	}
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	if err := this.setupLog(conf.Log); err != nil {
+		return err
+	}
+	this.applyLimits(conf.Limits)
+	for name, pc := range conf.Pools {
+		if p, ok := this.pools[name]; ok {
+			p.reload(pc)
+		} else {
+			log.Printf("QGate: new pool %s, restart to apply.\n", name)
+		}
+	}
+	if !reflect.DeepEqual(conf.Listen, this.conf.Listen) || conf.Heartbeat != this.conf.Heartbeat {
+		log.Println("QGate: listen or heartbeat settings changed, restart to apply.")
+	}
+	this.conf = conf
+	log.Printf("QGate: reload %s.\n", this.path)
+	return nil
+}
+
+// 停止接受新连接	在ShutdownTimeout内等待客户端断开	然后关闭后端池
+func (this *Gate) Shutdown() {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	ctx, cancel := context.WithTimeout(context.Background(), this.conf.ShutdownTimeout.D())
+	defer cancel()
+	wg := sync.WaitGroup{}
+	for _, gs := range this.servers {
+		wg.Add(1)
+		go func(gs *gateServer) {
+			defer wg.Done()
+			start := time.Now()
+			if err := gs.server.Shutdown(ctx); err != nil {
+				log.Printf("QGate: shutdown %s. %s.\n", gs.conf.Address, err.Error())
+				return
+			}
+			log.Printf("QGate: shutdown %s in %s.\n", gs.conf.Address, time.Since(start))
+		}(gs)
+	}
+	wg.Wait()
+	this.servers = nil
+	this.closeAll()
+}
+
+// 调用者持有锁
+func (this *Gate) closeAll() {
+	for _, gs := range this.servers {
+		gs.server.Close()
+	}
+	this.servers = nil
+	for name, p := range this.pools {
+		p.close()
+		delete(this.pools, name)
+	}
+	if this.logfile != nil {
+		log.SetOutput(os.Stderr)
+		this.logfile.Close()
+		this.logfile = nil
+	}
+}
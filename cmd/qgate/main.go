@@ -0,0 +1,60 @@
+// qgate 根据配置文件启动前端网关	把客户端连接转发到后端池
+//
+//	qgate -config qgate.json
+//	qgate -config qgate.json -check
+//
+// SIGHUP 重新加载配置	SIGUSR1 输出运行状态	SIGINT/SIGTERM 停止接受新连接并在shutdown_timeout内优雅退出
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"wwt/ctrl"
+)
+
+func main() {
+	path := flag.String("config", "qgate.json", "config file")
+	check := flag.Bool("check", false, "validate the config file and exit")
+	flag.Parse()
+
+	if *check {
+		if _, err := LoadConfig(*path); err != nil {
+			fatal(err)
+		}
+		fmt.Println("qgate: config ok")
+		return
+	}
+
+	gate, err := NewGate(*path)
+	if err != nil {
+		fatal(err)
+	}
+	if err := gate.Start(); err != nil {
+		fatal(err)
+	}
+
+	ctrl.OnReload(func() {
+		if err := gate.Reload(); err != nil {
+			log.Printf("QGate: reload fail. %s.\n", err.Error())
+		}
+	})
+	ctrl.EnableStatsDump()
+	exit := make(chan os.Signal, 1)
+	ctrl.OnTerminate(func(sig os.Signal) {
+		//	已经在退出时忽略后续的信号	不阻塞信号处理
+		select {
+		case exit <- sig:
+		default:
+		}
+	})
+	sig := <-exit
+	log.Printf("QGate: receive %s, shutting down.\n", sig)
+	gate.Shutdown()
+}
+
+func fatal(err error) {
+	fmt.Fprintln(os.Stderr, "qgate:", err)
+	os.Exit(1)
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"strings"
+	"unicode"
+)
+
+var goTypes = map[string]string{
+	"bool":    "bool",
+	"byte":    "byte",
+	"int":     "int",
+	"float32": "float32",
+	"float64": "float64",
+	"string":  "string",
+	"bytes":   "[]byte",
+}
+
+// snake_case / camelCase 转为导出的 CamelCase
+func exportName(s string) string {
+	parts := strings.Split(s, "_")
+	var b strings.Builder
+	for _, p := range parts {
+		if p == "" {
+			continue
+		}
+		r := []rune(p)
+		r[0] = unicode.ToUpper(r[0])
+		b.WriteString(string(r))
+	}
+	return b.String()
+}
+
+// LoginReq 转为 LOGIN_REQ
+func constName(s string) string {
+	var b strings.Builder
+	r := []rune(s)
+	for i, c := range r {
+		if i > 0 && unicode.IsUpper(c) && (unicode.IsLower(r[i-1]) || (i+1 < len(r) && unicode.IsLower(r[i+1]))) {
+			b.WriteByte('_')
+		}
+		b.WriteRune(unicode.ToUpper(c))
+	}
+	return b.String()
+}
+
+func goType(f *Field) string {
+	t, ok := goTypes[f.Type]
+	if !ok {
+		t = f.Type
+	}
+	if f.Repeated {
+		return "[]" + t
+	}
+	return t
+}
+
+type generator struct {
+	buf bytes.Buffer
+}
+
+func (this *generator) P(format string, args ...interface{}) {
+	fmt.Fprintf(&this.buf, format, args...)
+	this.buf.WriteByte('\n')
+}
+
+func (this *generator) writeValue(typ, expr string) {
+	switch typ {
+	case "bool":
+		this.P("if %s {", expr)
+		this.P("stream.WriteByte(1)")
+		this.P("} else {")
+		this.P("stream.WriteByte(0)")
+		this.P("}")
+	case "byte":
+		this.P("stream.WriteByte(%s)", expr)
+	case "int":
+		this.P("stream.WriteInt(%s)", expr)
+	case "float32":
+		this.P("stream.WriteFloat32(%s)", expr)
+	case "float64":
+		this.P("stream.WriteFloat64(%s)", expr)
+	case "string":
+		//	与bytes相同的长度前缀	字符串中可以包含换行符
+		this.P("stream.WriteInt(len(%s))", expr)
+		this.P("stream.Append([]byte(%s))", expr)
+	case "bytes":
+		this.P("stream.WriteInt(len(%s))", expr)
+		this.P("stream.Append(%s)", expr)
+	default:
+		this.P("%s.Marshal(stream)", expr)
+	}
+}
+
+func (this *generator) readValue(typ, target string) {
+	switch typ {
+	case "bool":
+		this.P("%s = stream.ReadByte() != 0", target)
+	case "byte":
+		this.P("%s = stream.ReadByte()", target)
+	case "int":
+		this.P("%s = stream.ReadInt()", target)
+	case "float32":
+		this.P("%s = stream.ReadFloat32()", target)
+	case "float64":
+		this.P("%s = stream.ReadFloat64()", target)
+	case "string":
+		this.P("%s = string(stream.ReadNBytes(util.ReadLen(stream)))", target)
+	case "bytes":
+		this.P("%s = stream.ReadNBytes(util.ReadLen(stream))", target)
+	default:
+		this.P("%s.Unmarshal(stream)", target)
+	}
+}
+
+func (this *generator) message(m *Message) {
+	this.P("")
+	if m.HasID {
+		this.P("// %s 消息ID %d", m.Name, m.ID)
+	}
+	this.P("type %s struct {", m.Name)
+	for _, f := range m.Fields {
+		this.P("%s %s", exportName(f.Name), goType(f))
+	}
+	this.P("}")
+
+	if m.HasID {
+		this.P("")
+		this.P("func (this *%s) MessageID() int {", m.Name)
+		this.P("return MSG_%s", constName(m.Name))
+		this.P("}")
+	}
+
+	this.P("")
+	this.P("func (this *%s) Marshal(stream util.StreamBuffer) {", m.Name)
+	for _, f := range m.Fields {
+		name := "this." + exportName(f.Name)
+		if f.Repeated {
+			this.P("stream.WriteInt(len(%s))", name)
+			this.P("for _, v := range %s {", name)
+			this.writeValue(f.Type, "v")
+			this.P("}")
+		} else {
+			this.writeValue(f.Type, name)
+		}
+	}
+	this.P("}")
+
+	this.P("")
+	this.P("func (this *%s) Unmarshal(stream util.StreamBuffer) {", m.Name)
+	for _, f := range m.Fields {
+		name := "this." + exportName(f.Name)
+		if f.Repeated {
+			//	长度来自对端	校验后再分配
+			this.P("%s = make(%s, util.ReadLen(stream))", name, goType(f))
+			this.P("for i := range %s {", name)
+			this.readValue(f.Type, name+"[i]")
+			this.P("}")
+		} else {
+			this.readValue(f.Type, name)
+		}
+	}
+	this.P("}")
+}
+
+// 生成Go源码
+func Generate(file *File, source string) ([]byte, error) {
+	g := &generator{}
+	var ided []*Message
+	for _, m := range file.Messages {
+		if m.HasID {
+			ided = append(ided, m)
+		}
+	}
+
+	g.P("// Code generated by qnetgen from %s. DO NOT EDIT.", source)
+	g.P("")
+	g.P("package %s", file.Package)
+	g.P("")
+	g.P("import (")
+	if len(ided) > 0 {
+		g.P("%q", "wwt/net/message")
+	}
+	g.P("%q", "wwt/util")
+	g.P(")")
+
+	if len(ided) > 0 {
+		g.P("")
+		g.P("const (")
+		for _, m := range ided {
+			g.P("MSG_%s = %d", constName(m.Name), m.ID)
+		}
+		g.P(")")
+	}
+
+	for _, m := range file.Messages {
+		g.message(m)
+	}
+
+	if len(ided) > 0 {
+		g.P("")
+		g.P("// 在注册表中注册所有带ID的消息	registry为nil时使用message.DefaultRegistry")
+		g.P("func RegisterMessages(registry *message.Registry) error {")
+		g.P("if registry == nil {")
+		g.P("registry = message.DefaultRegistry")
+		g.P("}")
+		for _, m := range ided {
+			g.P("if err := registry.Register(MSG_%s, (*%s)(nil), message.StreamCodec()); err != nil {", constName(m.Name), m.Name)
+			g.P("return err")
+			g.P("}")
+		}
+		g.P("return nil")
+		g.P("}")
+
+		g.P("")
+		g.P("// 消息处理接口	session为收到消息的TokenHandler或ClientHandler")
+		g.P("type Handler interface {")
+		for _, m := range ided {
+			g.P("Handle%s(session interface{}, msg *%s)", m.Name, m.Name)
+		}
+		g.P("}")
+
+		g.P("")
+		g.P("// 将Handler的所有方法注册到router")
+		g.P("func RegisterHandlers(router *message.Router, h Handler) error {")
+		for _, m := range ided {
+			g.P("if err := router.Handle(h.Handle%s); err != nil {", m.Name)
+			g.P("return err")
+			g.P("}")
+		}
+		g.P("return nil")
+		g.P("}")
+	}
+
+	out, err := format.Source(g.buf.Bytes())
+	if err != nil {
+		return g.buf.Bytes(), fmt.Errorf("format generated code: %s", err.Error())
+	}
+	return out, nil
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"wwt/cmd/qnetgen/testdata/game"
+	"wwt/util"
+)
+
+var update = flag.Bool("update", false, "rewrite golden files")
+
+const (
+	GAME_SOURCE = "testdata/game.qnet"
+	//	生成结果	同时作为往返测试使用的包
+	GAME_GOLDEN = "testdata/game/game.go"
+)
+
+func generateFile(t *testing.T, path string) []byte {
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	file, err := Parse(f)
+	if err != nil {
+		t.Fatalf("%s: %s", path, err.Error())
+	}
+	code, err := Generate(file, filepath.Base(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return code
+}
+
+func TestGolden(t *testing.T) {
+	code := generateFile(t, GAME_SOURCE)
+	if *update {
+		if err := ioutil.WriteFile(GAME_GOLDEN, code, 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := ioutil.ReadFile(GAME_GOLDEN)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(want, code) {
+		t.Errorf("generated code differs from %s	run go test -update to rewrite", GAME_GOLDEN)
+	}
+}
+
+type marshaler interface {
+	Marshal(stream util.StreamBuffer)
+	Unmarshal(stream util.StreamBuffer)
+}
+
+func roundTrip(t *testing.T, in marshaler, out marshaler) {
+	stream := util.NewStreamBuffer()
+	in.Marshal(stream)
+	decode := util.NewStreamBuffer()
+	decode.Append(stream.Bytes())
+	out.Unmarshal(decode)
+	if !reflect.DeepEqual(in, out) {
+		t.Errorf("round trip %T:\n got %+v\nwant %+v", in, out, in)
+	}
+	if decode.Len() != 0 {
+		t.Errorf("round trip %T: %d bytes left", in, decode.Len())
+	}
+}
+
+// 覆盖所有字段类型	string中包含换行符与空串
+func TestRoundTrip(t *testing.T) {
+	roundTrip(t, &game.Vec3{X: 1.5, Y: -2.25, Z: 1e10}, &game.Vec3{})
+	roundTrip(t, &game.LoginReq{
+		UserName: "b\nc\r\n",
+		Token:    []byte{0, 1, '\n', 255},
+		Version:  -7,
+	}, &game.LoginReq{})
+	roundTrip(t, &game.LoginReq{UserName: "", Token: []byte{}, Version: 0}, &game.LoginReq{})
+	roundTrip(t, &game.LoginAck{
+		Ok:       true,
+		PlayerId: 1 << 30,
+		Pos:      game.Vec3{X: 1, Y: 2, Z: 3},
+		Items:    []int{1, -1, 0},
+	}, &game.LoginAck{})
+	roundTrip(t, &game.MoveNotify{
+		PlayerId: 42,
+		Path:     []game.Vec3{{X: 1}, {Y: 2}, {Z: 3}},
+		Stamp:    3.141592653589793,
+		Flag:     0xfe,
+		Tags:     []string{"a", "", "multi\nline"},
+	}, &game.MoveNotify{})
+}
+
+// 对端伪造的长度在分配内存前被拒绝
+func TestInvalidLength(t *testing.T) {
+	for _, n := range []int{-1, 1 << 30} {
+		stream := util.NewStreamBuffer()
+		stream.WriteInt(n)
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("length %d: expected panic", n)
+				}
+			}()
+			(&game.LoginReq{}).Unmarshal(stream)
+		}()
+	}
+}
@@ -0,0 +1,78 @@
+// qnetgen 根据消息定义文件生成带StreamBuffer读写方法的Go类型
+//
+//	qnetgen -o game_msg.go game.qnet
+//	qnetgen -check testdata/game/game.go testdata/game.qnet
+//
+// -check 将生成结果与golden文件比较	不一致时返回非0
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+func main() {
+	out := flag.String("o", "", "output file (default <input>.go)")
+	pkg := flag.String("package", "", "override package name")
+	check := flag.String("check", "", "compare output with golden file instead of writing")
+	update := flag.Bool("update", false, "rewrite the golden file given by -check")
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "usage: qnetgen [-o out.go] [-package name] [-check golden [-update]] input.qnet")
+		os.Exit(2)
+	}
+	input := flag.Arg(0)
+
+	f, err := os.Open(input)
+	if err != nil {
+		fatal(err)
+	}
+	file, err := Parse(f)
+	f.Close()
+	if err != nil {
+		fatal(fmt.Errorf("%s: %s", input, err.Error()))
+	}
+	if *pkg != "" {
+		file.Package = *pkg
+	}
+
+	code, err := Generate(file, filepath.Base(input))
+	if err != nil {
+		fatal(err)
+	}
+
+	if *check != "" {
+		if *update {
+			if err := ioutil.WriteFile(*check, code, 0644); err != nil {
+				fatal(err)
+			}
+			return
+		}
+		golden, err := ioutil.ReadFile(*check)
+		if err != nil {
+			fatal(err)
+		}
+		if !bytes.Equal(golden, code) {
+			fatal(fmt.Errorf("%s: generated code differs from golden file %s", input, *check))
+		}
+		return
+	}
+
+	if *out == "" {
+		*out = strings.TrimSuffix(input, filepath.Ext(input)) + ".go"
+	}
+	if err := ioutil.WriteFile(*out, code, 0644); err != nil {
+		fatal(err)
+	}
+}
+
+func fatal(err error) {
+	fmt.Fprintln(os.Stderr, "qnetgen:", err)
+	os.Exit(1)
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// 内置字段类型
+var builtinTypes = map[string]bool{
+	"bool":    true,
+	"byte":    true,
+	"int":     true,
+	"float32": true,
+	"float64": true,
+	"string":  true,
+	"bytes":   true,
+}
+
+type Field struct {
+	Name     string
+	Type     string //	元素类型
+	Repeated bool
+	Line     int
+}
+
+type Message struct {
+	Name   string
+	ID     int
+	HasID  bool
+	Fields []*Field
+	Line   int
+}
+
+type File struct {
+	Package  string
+	Messages []*Message
+}
+
+type parseError struct {
+	line int
+	msg  string
+}
+
+func (this *parseError) Error() string {
+	return fmt.Sprintf("line %d: %s", this.line, this.msg)
+}
+
+func isIdent(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i, c := range s {
+		if c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (i > 0 && c >= '0' && c <= '9') {
+			continue
+		}
+		return false
+	}
+	return true
+}
+
+// 解析消息定义文件
+//
+//	package game
+//
+//	message Vec3 {
+//		float32 x
+//	}
+//
+//	message LoginReq = 1 {
+//		string name
+//		Vec3   pos
+//		[]int  items
+//	}
+func Parse(r io.Reader) (*File, error) {
+	file := &File{}
+	var cur *Message
+	scanner := bufio.NewScanner(r)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := scanner.Text()
+		if i := strings.Index(text, "//"); i >= 0 {
+			text = text[:i]
+		}
+		words := strings.Fields(text)
+		if len(words) == 0 {
+			continue
+		}
+
+		if cur != nil {
+			if len(words) == 1 && words[0] == "}" {
+				file.Messages = append(file.Messages, cur)
+				cur = nil
+				continue
+			}
+			if len(words) != 2 {
+				return nil, &parseError{line, "expected \"<type> <name>\""}
+			}
+			f := &Field{Name: words[1], Type: words[0], Line: line}
+			if strings.HasPrefix(f.Type, "[]") {
+				f.Repeated = true
+				f.Type = f.Type[2:]
+			}
+			if !isIdent(f.Type) || !isIdent(f.Name) {
+				return nil, &parseError{line, fmt.Sprintf("invalid field %q", strings.Join(words, " "))}
+			}
+			cur.Fields = append(cur.Fields, f)
+			continue
+		}
+
+		switch words[0] {
+		case "package":
+			if len(words) != 2 || !isIdent(words[1]) {
+				return nil, &parseError{line, "expected \"package <name>\""}
+			}
+			file.Package = words[1]
+		case "message":
+			//	message Name [= id] {
+			if len(words) < 3 || words[len(words)-1] != "{" || !isIdent(words[1]) {
+				return nil, &parseError{line, "expected \"message <Name> [= <id>] {\""}
+			}
+			cur = &Message{Name: words[1], Line: line}
+			switch len(words) {
+			case 3:
+			case 5:
+				if words[2] != "=" {
+					return nil, &parseError{line, "expected \"=\" before message id"}
+				}
+				id, err := strconv.Atoi(words[3])
+				if err != nil {
+					return nil, &parseError{line, "invalid message id " + words[3]}
+				}
+				cur.ID = id
+				cur.HasID = true
+			default:
+				return nil, &parseError{line, "expected \"message <Name> [= <id>] {\""}
+			}
+		default:
+			return nil, &parseError{line, "unexpected " + words[0]}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if cur != nil {
+		return nil, &parseError{cur.Line, "message " + cur.Name + " is not closed"}
+	}
+	if file.Package == "" {
+		return nil, &parseError{1, "missing package declaration"}
+	}
+	return file, file.check()
+}
+
+// 检查类型引用与ID是否重复
+func (this *File) check() error {
+	names := make(map[string]*Message)
+	ids := make(map[int]*Message)
+	for _, m := range this.Messages {
+		if builtinTypes[m.Name] {
+			return &parseError{m.Line, "message name " + m.Name + " is a builtin type"}
+		}
+		if _, ok := names[m.Name]; ok {
+			return &parseError{m.Line, "duplicate message " + m.Name}
+		}
+		names[m.Name] = m
+		if m.HasID {
+			if o, ok := ids[m.ID]; ok {
+				return &parseError{m.Line, fmt.Sprintf("message id %d already used by %s", m.ID, o.Name)}
+			}
+			ids[m.ID] = m
+		}
+	}
+	for _, m := range this.Messages {
+		fields := make(map[string]bool)
+		for _, f := range m.Fields {
+			if fields[f.Name] {
+				return &parseError{f.Line, "duplicate field " + f.Name}
+			}
+			fields[f.Name] = true
+			if !builtinTypes[f.Type] {
+				if _, ok := names[f.Type]; !ok {
+					return &parseError{f.Line, "unknown type " + f.Type}
+				}
+			}
+		}
+	}
+	return nil
+}
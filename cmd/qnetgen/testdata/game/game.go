@@ -0,0 +1,173 @@
+// Code generated by qnetgen from game.qnet. DO NOT EDIT.
+
+package game
+
+import (
+	"wwt/net/message"
+	"wwt/util"
+)
+
+const (
+	MSG_LOGIN_REQ   = 1
+	MSG_LOGIN_ACK   = 2
+	MSG_MOVE_NOTIFY = 3
+)
+
+type Vec3 struct {
+	X float32
+	Y float32
+	Z float32
+}
+
+func (this *Vec3) Marshal(stream util.StreamBuffer) {
+	stream.WriteFloat32(this.X)
+	stream.WriteFloat32(this.Y)
+	stream.WriteFloat32(this.Z)
+}
+
+func (this *Vec3) Unmarshal(stream util.StreamBuffer) {
+	this.X = stream.ReadFloat32()
+	this.Y = stream.ReadFloat32()
+	this.Z = stream.ReadFloat32()
+}
+
+// LoginReq 消息ID 1
+type LoginReq struct {
+	UserName string
+	Token    []byte
+	Version  int
+}
+
+func (this *LoginReq) MessageID() int {
+	return MSG_LOGIN_REQ
+}
+
+func (this *LoginReq) Marshal(stream util.StreamBuffer) {
+	stream.WriteInt(len(this.UserName))
+	stream.Append([]byte(this.UserName))
+	stream.WriteInt(len(this.Token))
+	stream.Append(this.Token)
+	stream.WriteInt(this.Version)
+}
+
+func (this *LoginReq) Unmarshal(stream util.StreamBuffer) {
+	this.UserName = string(stream.ReadNBytes(util.ReadLen(stream)))
+	this.Token = stream.ReadNBytes(util.ReadLen(stream))
+	this.Version = stream.ReadInt()
+}
+
+// LoginAck 消息ID 2
+type LoginAck struct {
+	Ok       bool
+	PlayerId int
+	Pos      Vec3
+	Items    []int
+}
+
+func (this *LoginAck) MessageID() int {
+	return MSG_LOGIN_ACK
+}
+
+func (this *LoginAck) Marshal(stream util.StreamBuffer) {
+	if this.Ok {
+		stream.WriteByte(1)
+	} else {
+		stream.WriteByte(0)
+	}
+	stream.WriteInt(this.PlayerId)
+	this.Pos.Marshal(stream)
+	stream.WriteInt(len(this.Items))
+	for _, v := range this.Items {
+		stream.WriteInt(v)
+	}
+}
+
+func (this *LoginAck) Unmarshal(stream util.StreamBuffer) {
+	this.Ok = stream.ReadByte() != 0
+	this.PlayerId = stream.ReadInt()
+	this.Pos.Unmarshal(stream)
+	this.Items = make([]int, util.ReadLen(stream))
+	for i := range this.Items {
+		this.Items[i] = stream.ReadInt()
+	}
+}
+
+// MoveNotify 消息ID 3
+type MoveNotify struct {
+	PlayerId int
+	Path     []Vec3
+	Stamp    float64
+	Flag     byte
+	Tags     []string
+}
+
+func (this *MoveNotify) MessageID() int {
+	return MSG_MOVE_NOTIFY
+}
+
+func (this *MoveNotify) Marshal(stream util.StreamBuffer) {
+	stream.WriteInt(this.PlayerId)
+	stream.WriteInt(len(this.Path))
+	for _, v := range this.Path {
+		v.Marshal(stream)
+	}
+	stream.WriteFloat64(this.Stamp)
+	stream.WriteByte(this.Flag)
+	stream.WriteInt(len(this.Tags))
+	for _, v := range this.Tags {
+		stream.WriteInt(len(v))
+		stream.Append([]byte(v))
+	}
+}
+
+func (this *MoveNotify) Unmarshal(stream util.StreamBuffer) {
+	this.PlayerId = stream.ReadInt()
+	this.Path = make([]Vec3, util.ReadLen(stream))
+	for i := range this.Path {
+		this.Path[i].Unmarshal(stream)
+	}
+	this.Stamp = stream.ReadFloat64()
+	this.Flag = stream.ReadByte()
+	this.Tags = make([]string, util.ReadLen(stream))
+	for i := range this.Tags {
+		this.Tags[i] = string(stream.ReadNBytes(util.ReadLen(stream)))
+	}
+}
+
+// 在注册表中注册所有带ID的消息	registry为nil时使用message.DefaultRegistry
+func RegisterMessages(registry *message.Registry) error {
+	if registry == nil {
+		registry = message.DefaultRegistry
+	}
+	if err := registry.Register(MSG_LOGIN_REQ, (*LoginReq)(nil), message.StreamCodec()); err != nil {
+		return err
+	}
+	if err := registry.Register(MSG_LOGIN_ACK, (*LoginAck)(nil), message.StreamCodec()); err != nil {
+		return err
+	}
+	if err := registry.Register(MSG_MOVE_NOTIFY, (*MoveNotify)(nil), message.StreamCodec()); err != nil {
+		return err
+	}
+	return nil
+}
+
+// 消息处理接口	session为收到消息的TokenHandler或ClientHandler
+type Handler interface {
+	HandleLoginReq(session interface{}, msg *LoginReq)
+	HandleLoginAck(session interface{}, msg *LoginAck)
+	HandleMoveNotify(session interface{}, msg *MoveNotify)
+}
+
+// 将Handler的所有方法注册到router
+func RegisterHandlers(router *message.Router, h Handler) error {
+	if err := router.Handle(h.HandleLoginReq); err != nil {
+		return err
+	}
+	if err := router.Handle(h.HandleLoginAck); err != nil {
+		return err
+	}
+	if err := router.Handle(h.HandleMoveNotify); err != nil {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+// qreplay 查看或回放QNet流量录制文件
+//
+//	qreplay -dump session.qrec
+//	qreplay -addr 127.0.0.1:9000 -speed 0 session.qrec
+//
+// -speed 1 为原速回放	0 表示不等待尽快发送	-outbound 回放客户端录制的文件
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"wwt/net/record"
+)
+
+func main() {
+	addr := flag.String("addr", "", "server address to replay into")
+	dump := flag.Bool("dump", false, "print the events instead of replaying")
+	speed := flag.Float64("speed", 1, "replay speed, 0 = as fast as possible")
+	outbound := flag.Bool("outbound", false, "replay outbound frames (client-side recordings)")
+	conns := flag.String("conn", "", "comma separated connection ids to replay")
+	verbose := flag.Bool("v", false, "print frames received during replay")
+	flag.Parse()
+
+	if flag.NArg() != 1 || (!*dump && *addr == "") {
+		fmt.Fprintln(os.Stderr, "usage: qreplay (-dump | -addr host:port [-speed n] [-outbound] [-conn ids]) file")
+		os.Exit(2)
+	}
+	path := flag.Arg(0)
+
+	if *dump {
+		if err := dumpFile(path); err != nil {
+			fatal(err)
+		}
+		return
+	}
+
+	opts := record.ReplayOptions{Speed: *speed, Outbound: *outbound}
+	if *conns != "" {
+		for _, s := range strings.Split(*conns, ",") {
+			id, err := strconv.ParseUint(strings.TrimSpace(s), 10, 64)
+			if err != nil {
+				fatal(err)
+			}
+			opts.Conns = append(opts.Conns, id)
+		}
+	}
+	if *verbose {
+		opts.OnFrame = func(conn uint64, frame []byte) {
+			fmt.Printf("conn %d <- %d bytes %s\n", conn, len(frame), preview(frame))
+		}
+	}
+	stats, err := record.ReplayFile(context.Background(), path, *addr, opts)
+	fmt.Printf("replayed %d frames on %d connections (%d dial failures), %d frames received, %s\n",
+		stats.Frames, stats.Conns, stats.DialFail, stats.Responses, stats.Duration)
+	if err != nil {
+		fatal(err)
+	}
+}
+
+func dumpFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	r, err := record.NewReader(f)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("recording started %s\n", r.Start().Format("2006-01-02 15:04:05.000"))
+	for {
+		e, err := r.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		switch e.Type {
+		case record.EVENT_OPEN:
+			fmt.Printf("%12s  conn %d open %s -> %s\n", e.Offset, e.Conn, e.Remote, e.Local)
+		case record.EVENT_CLOSE:
+			fmt.Printf("%12s  conn %d close\n", e.Offset, e.Conn)
+		default:
+			fmt.Printf("%12s  conn %d %-3s %d bytes %s\n", e.Offset, e.Conn, e.Type, len(e.Frame), preview(e.Frame))
+		}
+	}
+}
+
+func preview(b []byte) string {
+	if len(b) > 32 {
+		return hex.EncodeToString(b[:32]) + "..."
+	}
+	return hex.EncodeToString(b)
+}
+
+func fatal(err error) {
+	fmt.Fprintln(os.Stderr, "qreplay:", err)
+	os.Exit(1)
+}
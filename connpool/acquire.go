@@ -0,0 +1,173 @@
+package connpool
+
+import (
+	"context"
+	"errors"
+	"wwt/ctrl"
+	"wwt/net/client"
+	"wwt/net/server/connection"
+)
+
+var ErrPoolClosed = errors.New("connpool: pool closed")
+
+// 等待连接的调用者	ch 收到nil表示连接池已关闭
+type waiter struct {
+	token connection.TokenHandler
+	ch    chan client.ClientHandler
+}
+
+// 放回闲置连接	有等待者时直接交给队首的等待者	调用者持有锁
+func (this *connpool) putIdle(b *backend, c client.ClientHandler) {
+	if front := this.waiters.Front(); front != nil {
+		w := this.waiters.Remove(front).(*waiter)
+		b.hook[c] = w.token
+		w.ch <- c
+		return
+	}
+	b.idlec[c] = struct{}{}
+}
+
+// 有空余容量时为等待者新建一个连接	调用者持有锁
+// 没有可用后端时什么也不做	由replenish定期重试
+func (this *connpool) dialForWaiters(key string) {
+	order, err := this.ordered(key)
+	if err != nil {
+		return
+	}
+	for _, b := range order {
+		if b.size() >= this.opts.MaxSize {
+			continue
+		}
+		b.dialing++
+		ctrl.StartGoroutines(func() {
+			c, err := this.dial(b)
+			if err != nil {
+				return
+			}
+			this.mu.Lock()
+			this.putIdle(b, c)
+			this.mu.Unlock()
+		})
+		return
+	}
+}
+
+// 按key选择闲置连接或可以新建连接的后端	调用者持有锁
+// 一致性哈希优先保证落在同一后端	宁可新建连接也不借用其他后端的闲置连接
+func (this *connpool) pick(key string) (client.ClientHandler, *backend, error) {
+	order, err := this.ordered(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	sticky := this.opts.Strategy == CONSISTENT_HASH
+	for _, b := range order {
+		for k := range b.idlec {
+			delete(b.idlec, k)
+			return k, b, nil
+		}
+		if sticky && b.size() < this.opts.MaxSize {
+			return nil, b, nil
+		}
+	}
+	//	没有闲置连接	未达到上限时新建连接
+	for _, b := range order {
+		if b.size() < this.opts.MaxSize {
+			return nil, b, nil
+		}
+	}
+	return nil, nil, nil
+}
+
+// 关闭时唤醒所有等待者	调用者持有锁
+func (this *connpool) failWaiters() {
+	for e := this.waiters.Front(); e != nil; e = this.waiters.Front() {
+		w := this.waiters.Remove(e).(*waiter)
+		w.ch <- nil
+	}
+}
+
+func (this *connpool) Acquire(ctx context.Context, token connection.TokenHandler) (client.ClientHandler, error) {
+	return this.acquire(ctx, token, "", true)
+}
+
+// Acquire与GetConnectionByKey共用的获取流程	所有调用者按同一个队列先来后到
+// wait为false时不排队	有人排队或没有可用连接时立即返回
+func (this *connpool) acquire(ctx context.Context, token connection.TokenHandler, key string, wait bool) (client.ClientHandler, error) {
+	this.init()
+	this.mu.Lock()
+	if this.closed {
+		this.mu.Unlock()
+		return nil, ErrPoolClosed
+	}
+	//	没有人排队时才能直接取闲置连接或新建连接
+	if this.waiters.Len() == 0 && len(this.backends) > 0 {
+		c, b, err := this.pick(key)
+		if err != nil {
+			//	所有后端都不可用	不排队等待
+			this.mu.Unlock()
+			return nil, err
+		}
+		if c != nil {
+			b.hook[c] = token
+			this.mu.Unlock()
+			return c, nil
+		}
+		if b != nil && !wait {
+			b.dialing++
+			this.mu.Unlock()
+			c, err = this.dial(b)
+			if err != nil {
+				return nil, err
+			}
+			this.mu.Lock()
+			b.hook[c] = token
+			this.mu.Unlock()
+			return c, nil
+		}
+	}
+	if !wait {
+		this.mu.Unlock()
+		return nil, ErrPoolExhausted
+	}
+
+	w := &waiter{token, make(chan client.ClientHandler, 1)}
+	e := this.waiters.PushBack(w)
+	this.dialForWaiters(key)
+	this.mu.Unlock()
+
+	select {
+	case c := <-w.ch:
+		if c == nil {
+			return nil, ErrPoolClosed
+		}
+		return c, nil
+	case <-ctx.Done():
+		this.mu.Lock()
+		select {
+		case c := <-w.ch:
+			//	取消的同时已经分配到连接	归还给下一个等待者
+			this.mu.Unlock()
+			if c != nil {
+				this.Release(c)
+			}
+		default:
+			this.waiters.Remove(e)
+			this.mu.Unlock()
+		}
+		return nil, ctx.Err()
+	}
+}
+
+func (this *connpool) Release(handler client.ClientHandler) {
+	this.RecyclingConnection(handler)
+}
+
+// 当前排队等待连接的调用者数
+func (this *connpool) Waiting() int {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	if this.waiters == nil {
+		return 0
+	}
+	return this.waiters.Len()
+}
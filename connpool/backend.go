@@ -0,0 +1,97 @@
+package connpool
+
+import (
+	"context"
+	"wwt/net/client"
+	"wwt/net/rpc"
+	"wwt/net/server/connection"
+	"wwt/util/breaker"
+)
+
+// 单个后端地址上的连接	由connpool的锁保护
+type backend struct {
+	address string
+	weight  int
+	current int //	平滑加权轮询的当前权重
+
+	idlec   map[client.ClientHandler]struct{}                //	闲置的连接
+	hook    map[client.ClientHandler]connection.TokenHandler //	连接与Token挂钩
+	dialing int
+
+	inflight map[client.ClientHandler]int //	共享连接上未完成的多路复用请求数	不与idlec重叠
+	pending  int                          //	所有连接上未完成的请求总数
+
+	failed    int64
+	lost      int64
+	unhealthy int64
+
+	breaker *breaker.Breaker //	熔断器	打开期间暂时剔除
+	removed bool             //	已从连接池移除	不再分配与补充
+}
+
+func newBackend(address string, weight int, opts breaker.Options) *backend {
+	if weight < 1 {
+		weight = 1
+	}
+	return &backend{
+		address: address,
+		weight:  weight,
+		idlec:   make(map[client.ClientHandler]struct{}),
+		hook:    make(map[client.ClientHandler]connection.TokenHandler),
+
+		inflight: make(map[client.ClientHandler]int),
+		breaker:  breaker.New(address, opts),
+	}
+}
+
+func (this *backend) size() int {
+	return len(this.idlec) + len(this.hook) + len(this.inflight) + this.dialing
+}
+
+// 负载	用于LEAST_INFLIGHT
+func (this *backend) load() int {
+	return len(this.hook) + this.pending
+}
+
+func (this *backend) available() bool {
+	return !this.removed && this.breaker.Ready()
+}
+
+// 记录一次请求结果	用于熔断与异常检测	只有连接错误与超时计为失败
+func (this *backend) record(err error) {
+	if _, ok := err.(*rpc.RemoteError); ok {
+		//	后端正常返回了错误应答	属于应用层错误
+		err = nil
+	}
+	switch {
+	case err == nil:
+		this.breaker.Success()
+	case err == rpc.ErrCallTimeout:
+		this.breaker.Timeout()
+	case err == context.Canceled || err == context.DeadlineExceeded:
+		//	调用方取消或调用方的ctx到期	与后端无关
+		this.breaker.Release()
+	default:
+		this.breaker.Failure()
+	}
+}
+
+func (this *backend) stats() BackendStats {
+	state := this.breaker.State()
+	return BackendStats{
+		Address: this.address,
+		Weight:  this.weight,
+		Ejected: state == breaker.OPEN,
+		Breaker: state,
+		PoolStats: PoolStats{
+			Idle:      len(this.idlec),
+			Busy:      len(this.hook),
+			Shared:    len(this.inflight),
+			Dialing:   this.dialing,
+			Inflight:  this.pending,
+			Failed:    this.failed,
+			Lost:      this.lost,
+			Unhealthy: this.unhealthy,
+		},
+	}
+}
@@ -0,0 +1,101 @@
+package connpool
+
+import (
+	"hash/crc32"
+	"sort"
+	"strconv"
+)
+
+// 负载均衡策略
+type Strategy int
+
+const (
+	ROUND_ROBIN     Strategy = iota //	轮询
+	LEAST_INFLIGHT                  //	正在处理的请求最少
+	WEIGHTED                        //	按权重平滑轮询
+	CONSISTENT_HASH                 //	按调用者给出的key一致性哈希
+
+	HASH_REPLICAS = 128 //	一致性哈希中每单位权重的虚拟节点数
+)
+
+type balancer struct {
+	strategy Strategy
+	next     int
+
+	ring  []uint32
+	nodes map[uint32]*backend
+}
+
+// 后端集合变化后调用
+func (this *balancer) rebuild(backends []*backend) {
+	if this.strategy != CONSISTENT_HASH {
+		return
+	}
+	this.ring = this.ring[:0]
+	this.nodes = make(map[uint32]*backend)
+	for _, b := range backends {
+		for i := 0; i < HASH_REPLICAS*b.weight; i++ {
+			h := crc32.ChecksumIEEE([]byte(b.address + "#" + strconv.Itoa(i)))
+			if _, ok := this.nodes[h]; ok {
+				continue
+			}
+			this.nodes[h] = b
+			this.ring = append(this.ring, h)
+		}
+	}
+	sort.Slice(this.ring, func(i, j int) bool {
+		return this.ring[i] < this.ring[j]
+	})
+}
+
+// 从可用后端中选择一个	cands 均为可用后端
+func (this *balancer) pick(cands []*backend, key string) *backend {
+	if len(cands) == 0 {
+		return nil
+	}
+	switch this.strategy {
+	case LEAST_INFLIGHT:
+		var res *backend
+		for _, b := range cands {
+			if res == nil || b.load() < res.load() {
+				res = b
+			}
+		}
+		return res
+	case WEIGHTED:
+		//	平滑加权轮询
+		total := 0
+		var res *backend
+		for _, b := range cands {
+			b.current += b.weight
+			total += b.weight
+			if res == nil || b.current > res.current {
+				res = b
+			}
+		}
+		res.current -= total
+		return res
+	case CONSISTENT_HASH:
+		if len(this.ring) > 0 {
+			usable := make(map[*backend]bool, len(cands))
+			for _, b := range cands {
+				usable[b] = true
+			}
+			h := crc32.ChecksumIEEE([]byte(key))
+			i := sort.Search(len(this.ring), func(i int) bool {
+				return this.ring[i] >= h
+			})
+			//	顺时针找到第一个可用后端
+			for n := 0; n < len(this.ring); n++ {
+				b := this.nodes[this.ring[(i+n)%len(this.ring)]]
+				if usable[b] {
+					return b
+				}
+			}
+		}
+		return cands[0]
+	default:
+		this.next++
+		return cands[this.next%len(cands)]
+	}
+}
@@ -0,0 +1,176 @@
+package connpool
+
+import (
+	"context"
+	"log"
+	"wwt/ctrl"
+	"wwt/net/client"
+	"wwt/net/rpc"
+	"wwt/net/server/connection"
+)
+
+// 为多路复用请求选择连接	优先选择未完成请求最少且未达到上限的共享连接
+// 共享连接从闲置连接中取出	请求未全部完成前不会交给GetConnection/Acquire独占使用
+// 返回前已向后端的熔断器申请	调用者需以record或Release报告这次请求的结果
+func (this *connpool) acquireShared(key string) (client.ClientHandler, *backend, error) {
+	this.mu.Lock()
+	if this.closed || len(this.backends) == 0 {
+		this.mu.Unlock()
+		return nil, nil, ErrPoolExhausted
+	}
+	order, err := this.ordered(key)
+	if err != nil {
+		this.mu.Unlock()
+		return nil, nil, err
+	}
+	sticky := this.opts.Strategy == CONSISTENT_HASH
+	var target *backend
+	for _, b := range order {
+		var best client.ClientHandler
+		for k, n := range b.inflight {
+			if n < this.opts.MaxInflight && (best == nil || n < b.inflight[best]) {
+				best = k
+			}
+		}
+		idle := false
+		if best == nil {
+			for k := range b.idlec {
+				best = k
+				idle = true
+				break
+			}
+		}
+		if best != nil && !b.breaker.Allow() {
+			//	半开状态的探测名额已被占用
+			continue
+		}
+		if best != nil {
+			if idle {
+				delete(b.idlec, best)
+			}
+			b.inflight[best]++
+			b.pending++
+			this.mu.Unlock()
+			return best, b, nil
+		}
+		if sticky && b.size() < this.opts.MaxSize {
+			target = b
+			break
+		}
+	}
+	for i := 0; target == nil && i < len(order); i++ {
+		if order[i].size() < this.opts.MaxSize {
+			target = order[i]
+		}
+	}
+	if target == nil {
+		this.mu.Unlock()
+		return nil, nil, ErrPoolExhausted
+	}
+	target.dialing++
+	this.mu.Unlock()
+
+	c, err := this.dial(target)
+	if err != nil {
+		return nil, nil, err
+	}
+	this.mu.Lock()
+	if !target.breaker.Allow() {
+		this.putIdle(target, c)
+		this.mu.Unlock()
+		return nil, nil, ErrNoBackend
+	}
+	target.inflight[c]++
+	target.pending++
+	this.mu.Unlock()
+	return c, target, nil
+}
+
+// 请求结束	连接上没有未完成的请求时放回闲置连接
+func (this *connpool) releaseShared(c client.ClientHandler, b *backend) {
+	this.mu.Lock()
+	drained := false
+	if n, ok := b.inflight[c]; ok {
+		b.pending--
+		if n <= 1 {
+			delete(b.inflight, c)
+			if b.removed || this.closed {
+				//	后端已移除	最后一个请求结束后关闭连接
+				delete(this.owner, c)
+				drained = true
+			} else {
+				this.putIdle(b, c)
+			}
+		} else {
+			b.inflight[c] = n - 1
+		}
+	}
+	this.mu.Unlock()
+	if drained {
+		c.Close()
+	}
+}
+
+func (this *connpool) Call(ctx context.Context, key string, payload []byte) ([]byte, error) {
+	this.init()
+	_, own := ctx.Deadline()
+	if !own {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, this.opts.RequestTimeout)
+		defer cancel()
+	}
+	c, b, err := this.acquireShared(key)
+	if err != nil {
+		return nil, err
+	}
+	res, err := c.Call(ctx, payload)
+	switch {
+	case err == rpc.ErrConnectionClosed:
+		//	连接断开已在ProcessClose中记录
+		b.breaker.Release()
+	case err == rpc.ErrCallTimeout && own:
+		//	调用方自己的截止时间	只有RequestTimeout超时才计入后端
+		b.record(context.DeadlineExceeded)
+	default:
+		b.record(err)
+	}
+	this.releaseShared(c, b)
+	return res, err
+}
+
+// 转发失败时的默认处理	以错误帧写回token	id 为token请求的ID	原样转发时为0
+func (this *connpool) forwardError(token connection.TokenHandler, id uint32, err error) {
+	if this.opts.OnRequestError != nil {
+		this.opts.OnRequestError(token, err)
+		return
+	}
+	log.Printf("Connpool %p: Forward request from %s fail. %s.\n", this, token.RemoteAddr(), err.Error())
+	msg := err.Error()
+	if remote, ok := err.(*rpc.RemoteError); ok {
+		msg = remote.Message //	后端返回的错误原样转交
+	}
+	token.Write(rpc.Encode(rpc.KIND_ERROR, id, []byte(msg)))
+}
+
+// payload 为请求帧时只转发请求内容	应答与错误以token请求的ID回复	token一侧的Call可以得到结果
+// 否则原样转发	应答原样写回
+func (this *connpool) Forward(token connection.TokenHandler, key string, payload []byte) {
+	var id uint32
+	req, isRequest := rpc.ParseRequest(payload)
+	if isRequest {
+		id = req.ID
+		payload = req.Body
+	}
+	ctrl.StartGoroutines(func() {
+		res, err := this.Call(context.Background(), key, payload)
+		if err != nil {
+			this.forwardError(token, id, err)
+			return
+		}
+		if isRequest {
+			rpc.Reply(token, req, res)
+			return
+		}
+		token.Write(res)
+	})
+}
@@ -1,111 +1,630 @@
 package connpool
 
 import (
+	"container/list"
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+	"wwt/ctrl"
 	"wwt/net/client"
 	"wwt/net/server/connection"
-	"sync"
+	"wwt/util/breaker"
 )
 
+const (
+	DEFAULT_HEALTH_INTERVAL    = 30 * time.Second
+	DEFAULT_REPLENISH_INTERVAL = time.Second
+	DEFAULT_MAX_SIZE           = 16
+	DEFAULT_EJECT_THRESHOLD    = 3
+	DEFAULT_EJECT_DURATION     = 30 * time.Second
+	DEFAULT_MAX_INFLIGHT       = 128
+	DEFAULT_REQUEST_TIMEOUT    = 10 * time.Second
+)
+
+var ErrConnectionClosed = errors.New("connpool: connection closed")
+var ErrPoolExhausted = errors.New("connpool: no connection available")
+var ErrNoBackend = errors.New("connpool: no available backend")
+
+// 多路复用请求失败时的回调
+type RequestErrorFunc func(token connection.TokenHandler, err error)
+
+// 健康检查	返回error时该连接被关闭并由后台补充
+type HealthCheckFunc func(client.ClientHandler) error
+
+// 连接池配置	零值字段使用默认值
+type PoolOptions struct {
+	MinSize int //	每个后端保持的最少连接数
+	MaxSize int //	每个后端的连接数上限	为零时使用DEFAULT_MAX_SIZE	<MinSize 时等于MinSize
+
+	Dial client.DialOptions //	拨号参数
+
+	HealthCheckInterval time.Duration   //	健康检查间隔	<0 表示关闭
+	HealthCheck         HealthCheckFunc //	为nil时发送心跳包并检查连接状态	只检查存活不等待应答
+	ReplenishInterval   time.Duration   //	补充连接的检查间隔
+
+	Strategy       Strategy      //	多个后端之间的负载均衡策略
+	EjectThreshold int           //	连续失败多少次后暂时剔除该后端	<0 表示不剔除
+	EjectDuration  time.Duration //	剔除时长
+
+	//	每个后端的熔断器	按错误率与超时率做异常检测	状态变化通过OnStateChange通知
+	//	FailureThreshold与Cooldown为零时使用EjectThreshold与EjectDuration
+	Breaker breaker.Options
+
+	MaxInflight    int              //	每个连接上同时未完成的请求数上限
+	RequestTimeout time.Duration    //	多路复用请求的默认超时	ctx没有截止时间时生效
+	OnRequestError RequestErrorFunc //	Forward失败时的回调	为nil时记录日志并向token写回错误帧
+}
+
+func (this *PoolOptions) normalize() {
+	if this.MinSize < 0 {
+		this.MinSize = 0
+	}
+	if this.MaxSize <= 0 {
+		this.MaxSize = DEFAULT_MAX_SIZE
+	}
+	if this.MaxSize < this.MinSize {
+		this.MaxSize = this.MinSize
+	}
+	if this.HealthCheckInterval == 0 {
+		this.HealthCheckInterval = DEFAULT_HEALTH_INTERVAL
+	}
+	if this.HealthCheck == nil {
+		this.HealthCheck = defaultHealthCheck
+	}
+	if this.ReplenishInterval <= 0 {
+		this.ReplenishInterval = DEFAULT_REPLENISH_INTERVAL
+	}
+	if this.EjectThreshold == 0 {
+		this.EjectThreshold = DEFAULT_EJECT_THRESHOLD
+	}
+	if this.EjectDuration <= 0 {
+		this.EjectDuration = DEFAULT_EJECT_DURATION
+	}
+	if this.Breaker.FailureThreshold == 0 {
+		this.Breaker.FailureThreshold = this.EjectThreshold
+	}
+	if this.Breaker.Cooldown <= 0 {
+		this.Breaker.Cooldown = this.EjectDuration
+	}
+	if this.MaxInflight <= 0 {
+		this.MaxInflight = DEFAULT_MAX_INFLIGHT
+	}
+	if this.RequestTimeout <= 0 {
+		this.RequestTimeout = DEFAULT_REQUEST_TIMEOUT
+	}
+}
+
+// 默认健康检查只检查连接是否存活	不等待应答
+// 对端进程挂起但TCP连接仍然保持时检查仍会通过	需要确认后端可以应答时使用CallHealthCheck
+func defaultHealthCheck(c client.ClientHandler) error {
+	if c.IsClosed() {
+		return ErrConnectionClosed
+	}
+	c.Write(make([]byte, 0)) //	心跳包	连接异常时写入失败会关闭连接
+	return nil
+}
+
+// 以请求/应答做健康检查	timeout内没有收到应答或后端返回错误时视为不健康
+// 后端需使用server.RPCProcesser应答payload
+func CallHealthCheck(payload []byte, timeout time.Duration) HealthCheckFunc {
+	return func(c client.ClientHandler) error {
+		if c.IsClosed() {
+			return ErrConnectionClosed
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		_, err := c.Call(ctx, payload)
+		return err
+	}
+}
+
+// 连接池状态
+type PoolStats struct {
+	Idle     int //	闲置连接数
+	Busy     int //	被Token占用的连接数
+	Shared   int //	正在承载多路复用请求的连接数
+	Dialing  int //	正在建立的连接数
+	Inflight int //	未完成的多路复用请求数
+
+	Failed    int64 //	拨号失败次数
+	Lost      int64 //	意外断开的连接数
+	Unhealthy int64 //	健康检查失败被关闭的连接数
+}
+
+// 单个后端的状态
+type BackendStats struct {
+	Address string
+	Weight  int
+	Ejected bool          //	是否被暂时剔除
+	Breaker breaker.State //	熔断器状态
+	PoolStats
+}
+
 // 连接池
 type ConnectionPoolHandler interface {
-	//	对同一个目标终端建立连接
+	//	对同一个目标终端建立连接	连接数由创建时的配置决定	count 只在New中使用
 	Connect(address string, count int)
 
+	//	增加后端	已存在时更新权重
+	AddBackend(address string, weight int)
+
+	//	移除后端	闲置连接立即关闭	使用中的连接在回收时关闭
+	RemoveBackend(address string)
+
 	//	获取一个连接
 	GetConnection(token connection.TokenHandler) client.ClientHandler
 
+	//	按key选择后端并获取连接	CONSISTENT_HASH 策略下相同key落在同一后端
+	//	不排队	有调用者在Acquire中排队时返回nil
+	GetConnectionByKey(token connection.TokenHandler, key string) client.ClientHandler
+
+	//	多路复用请求	同一连接上可以同时有多个未完成的请求	后端需使用server.RPCProcesser应答
+	Call(ctx context.Context, key string, payload []byte) ([]byte, error)
+
+	//	异步转发请求	应答写回token	失败时调用OnRequestError	为nil时向token写回错误帧
+	Forward(token connection.TokenHandler, key string, payload []byte)
+
 	//	回收一个连接
 	RecyclingConnection(handler client.ClientHandler)
 
+	//	获取一个连接	没有闲置连接时按先来后到排队等待	ctx取消或超时时返回错误
+	Acquire(ctx context.Context, token connection.TokenHandler) (client.ClientHandler, error)
+
+	//	归还Acquire得到的连接	有等待者时直接交给队首
+	Release(handler client.ClientHandler)
+
+	//	排队等待连接的调用者数
+	Waiting() int
+
 	//	处理消息
 	ProcessResponse(client.ClientHandler, int, []byte)
 
 	//	关闭
 	ProcessClose(handler client.ClientHandler)
 
+	//	连接池状态	所有后端的总和
+	Stats() PoolStats
+
+	//	每个后端的状态
+	Backends() []BackendStats
+
 	//	Close
 	Close()
 }
 
 type connpool struct {
-	idlec_mu sync.Mutex
-	idlec    map[client.ClientHandler]struct{} //	闲置的连接
-	hook_mu  sync.Mutex
-	hook     map[client.ClientHandler]connection.TokenHandler //	连接与Token挂钩
+	opts PoolOptions
+
+	mu       sync.Mutex
+	backends []*backend
+	owner    map[client.ClientHandler]*backend //	连接所属的后端
+	lb       balancer
+	waiters  *list.List //	Acquire的等待队列	元素为*waiter
+
+	init_once sync.Once
+	closed    bool
+	exit      chan struct{}
+	wake      chan struct{} //	连接断开时通知后台补充
+}
+
+func (this *connpool) init() {
+	this.init_once.Do(func() {
+		this.owner = make(map[client.ClientHandler]*backend)
+		this.lb = balancer{strategy: this.opts.Strategy}
+		this.waiters = list.New()
+		this.exit = make(chan struct{})
+		this.wake = make(chan struct{}, 1)
+		ctrl.StartGoroutines(func() {
+			this.maintain()
+		})
+	})
 }
 
 func (this *connpool) Close() {
-	this.idlec_mu.Lock()
-	this.hook_mu.Lock()
-	defer this.hook_mu.Unlock()
-	defer this.idlec_mu.Unlock()
-	for k, _ := range this.hook {
-		this.idlec[k] = struct{}{}
-		delete(this.hook, k)
+	this.mu.Lock()
+	if this.closed {
+		this.mu.Unlock()
+		return
+	}
+	this.closed = true
+	close(this.exit)
+	this.failWaiters()
+	conns := make([]client.ClientHandler, 0, len(this.owner))
+	for k := range this.owner {
+		conns = append(conns, k)
 	}
-	for k, _ := range this.idlec {
-		k.Close()
+	this.owner = make(map[client.ClientHandler]*backend)
+	for _, b := range this.backends {
+		b.idlec = make(map[client.ClientHandler]struct{})
+		b.hook = make(map[client.ClientHandler]connection.TokenHandler)
+		b.inflight = make(map[client.ClientHandler]int)
+		b.pending = 0
 	}
-	this.idlec = nil
-	this.hook = nil
+	this.mu.Unlock()
 
+	//	ProcessClose 会加锁	不能在持有锁时关闭连接
+	for _, c := range conns {
+		c.Close()
+	}
 }
 
 func (this *connpool) Connect(address string, count int) {
-	this.idlec = make(map[client.ClientHandler]struct{})
-	this.hook = make(map[client.ClientHandler]connection.TokenHandler)
-	this.idlec_mu = sync.Mutex{}
-	this.hook_mu = sync.Mutex{}
-	for i := 0; i < count; i++ {
-		qc := client.QClient{}
-		qc.Dial(address, this.ProcessResponse, this.ProcessClose)
-		this.idlec[&qc] = struct{}{}
+	this.AddBackend(address, 1)
+}
+
+func (this *connpool) AddBackend(address string, weight int) {
+	this.init()
+	this.mu.Lock()
+	if this.closed {
+		this.mu.Unlock()
+		return
+	}
+	var b *backend
+	for _, v := range this.backends {
+		if v.address == address {
+			b = v
+			break
+		}
 	}
+	if b == nil {
+		b = newBackend(address, weight, this.breakerOptions())
+		this.backends = append(this.backends, b)
+	} else if weight > 0 {
+		b.weight = weight
+	}
+	this.lb.rebuild(this.backends)
+	this.mu.Unlock()
+
+	this.replenishBackend(b)
 }
 
-func (this *connpool) RecyclingConnection(handler client.ClientHandler) {
-	this.idlec_mu.Lock()
-	this.hook_mu.Lock()
-	defer this.hook_mu.Unlock()
-	defer this.idlec_mu.Unlock()
+func (this *connpool) RemoveBackend(address string) {
+	this.mu.Lock()
+	var b *backend
+	for i, v := range this.backends {
+		if v.address == address {
+			b = v
+			this.backends = append(this.backends[:i], this.backends[i+1:]...)
+			break
+		}
+	}
+	if b == nil {
+		this.mu.Unlock()
+		return
+	}
+	b.removed = true
+	this.lb.rebuild(this.backends)
+	//	共享连接在最后一个请求结束后关闭
+	conns := make([]client.ClientHandler, 0, len(b.idlec))
+	for k := range b.idlec {
+		conns = append(conns, k)
+		delete(b.idlec, k)
+		delete(this.owner, k)
+	}
+	this.mu.Unlock()
 
-	this.idlec[handler] = struct{}{}
+	for _, c := range conns {
+		c.Close()
+	}
+	log.Printf("Connpool %p: Remove backend %s.\n", this, address)
+}
+
+// 后端熔断器的配置	状态变化时记录日志并通知上层
+func (this *connpool) breakerOptions() breaker.Options {
+	opts := this.opts.Breaker
+	notify := opts.OnStateChange
+	opts.OnStateChange = func(address string, from breaker.State, to breaker.State) {
+		log.Printf("Connpool %p: Backend %s breaker %s -> %s.\n", this, address, from, to)
+		if notify != nil {
+			notify(address, from, to)
+		}
+	}
+	return opts
+}
+
+// 建立一个新连接	调用前需要 b.dialing++
+// 拨号前向熔断器申请	半开状态下同时拨号的探测数不超过HalfOpenMax
+// 拨号失败计入同一次申请	连接建立只归还名额	后端能否正常应答由之后的请求决定
+func (this *connpool) dial(b *backend) (client.ClientHandler, error) {
+	if !b.breaker.Allow() {
+		this.mu.Lock()
+		b.dialing--
+		this.mu.Unlock()
+		return nil, ErrNoBackend
+	}
+	qc := &client.QClient{}
+	qc.SetDialOptions(this.opts.Dial)
+	qc.SetRPC(true) //	多路复用请求依赖请求/应答帧
+	err := qc.Dial(b.address, this.ProcessResponse, this.ProcessClose)
+
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	b.dialing--
+	if err != nil {
+		b.failed++
+		log.Printf("Connpool %p: Dial %s fail. %s.\n", this, b.address, err.Error())
+		b.record(err)
+		return nil, err
+	}
+	b.breaker.Release()
+	if this.closed || b.removed {
+		ctrl.StartGoroutines(func() {
+			qc.Close()
+		})
+		return nil, ErrPoolClosed
+	}
+	this.owner[qc] = b
+	return qc, nil
+}
+
+// 补充后端连接至MinSize
+func (this *connpool) replenishBackend(b *backend) {
+	this.mu.Lock()
+	need := this.opts.MinSize - b.size()
+	if this.closed || !b.available() || need <= 0 {
+		this.mu.Unlock()
+		return
+	}
+	b.dialing += need
+	this.mu.Unlock()
+
+	for i := 0; i < need; i++ {
+		c, err := this.dial(b)
+		if err != nil {
+			continue
+		}
+		this.mu.Lock()
+		this.putIdle(b, c)
+		this.mu.Unlock()
+	}
+}
+
+func (this *connpool) replenish() {
+	this.mu.Lock()
+	backends := make([]*backend, len(this.backends))
+	copy(backends, this.backends)
+	this.mu.Unlock()
+	for _, b := range backends {
+		this.replenishBackend(b)
+	}
+
+	//	上次为等待者拨号失败时重试
+	this.mu.Lock()
+	if !this.closed && this.waiters.Len() > 0 {
+		this.dialForWaiters("")
+	}
+	this.mu.Unlock()
+}
+
+// 检查所有闲置连接
+func (this *connpool) healthCheck() {
+	this.mu.Lock()
+	conns := make([]client.ClientHandler, 0, len(this.owner))
+	for _, b := range this.backends {
+		for k := range b.idlec {
+			conns = append(conns, k)
+		}
+	}
+	this.mu.Unlock()
+
+	for _, c := range conns {
+		err := this.opts.HealthCheck(c)
+		if err == nil {
+			continue
+		}
+		this.mu.Lock()
+		b, ok := this.owner[c]
+		if ok {
+			_, ok = b.idlec[c]
+		}
+		if ok {
+			log.Printf("Connpool %p: Health check %s fail. %s.\n", this, b.address, err.Error())
+			delete(b.idlec, c)
+			delete(this.owner, c)
+			b.unhealthy++
+			b.record(err)
+		}
+		this.mu.Unlock()
+		if ok {
+			c.Close()
+		}
+	}
+}
+
+func (this *connpool) maintain() {
+	replenish := time.NewTicker(this.opts.ReplenishInterval)
+	defer replenish.Stop()
+	var health <-chan time.Time
+	if this.opts.HealthCheckInterval > 0 {
+		t := time.NewTicker(this.opts.HealthCheckInterval)
+		defer t.Stop()
+		health = t.C
+	}
+	for {
+		select {
+		case <-this.exit:
+			return
+		case <-this.wake:
+			this.replenish()
+		case <-replenish.C:
+			this.replenish()
+		case <-health:
+			this.healthCheck()
+			this.replenish()
+		}
+	}
+}
+
+func (this *connpool) RecyclingConnection(handler client.ClientHandler) {
+	this.mu.Lock()
+	b, ok := this.owner[handler]
+	if !ok || this.closed || handler.IsClosed() {
+		this.mu.Unlock()
+		return
+	}
+	delete(b.hook, handler)
+	if b.removed {
+		//	后端已被移除	不再放回连接池
+		delete(this.owner, handler)
+		this.mu.Unlock()
+		handler.Close()
+		return
+	}
+	this.putIdle(b, handler)
+	this.mu.Unlock()
 }
 
 func (this *connpool) GetConnection(token connection.TokenHandler) client.ClientHandler {
-	this.idlec_mu.Lock()
-	this.hook_mu.Lock()
-	defer this.hook_mu.Unlock()
-	defer this.idlec_mu.Unlock()
-	if len(this.idlec) == 0 {
-		return nil
-	}
-	var res client.ClientHandler
-	for k, _ := range this.idlec {
-		res = k
-		this.hook[res] = token
-		break
+	return this.GetConnectionByKey(token, "")
+}
+
+// 可用的后端	全部被剔除或熔断时返回ErrNoBackend	不向熔断中的后端发送请求
+func (this *connpool) candidates() ([]*backend, error) {
+	cands := make([]*backend, 0, len(this.backends))
+	for _, b := range this.backends {
+		if b.available() {
+			cands = append(cands, b)
+		}
 	}
-	return res
+	if len(cands) == 0 {
+		return nil, ErrNoBackend
+	}
+	return cands, nil
+}
+
+// 按负载均衡策略排列可用后端	选中的后端在最前	不可用时依次尝试其他后端	调用者持有锁
+func (this *connpool) ordered(key string) ([]*backend, error) {
+	cands, err := this.candidates()
+	if err != nil {
+		return nil, err
+	}
+	first := this.lb.pick(cands, key)
+	order := make([]*backend, 0, len(cands))
+	order = append(order, first)
+	for _, b := range cands {
+		if b != first {
+			order = append(order, b)
+		}
+	}
+	return order, nil
+}
+
+// 不排队	已有调用者在Acquire中排队时返回nil	不会抢在等待者之前占用闲置连接或新建连接的名额
+func (this *connpool) GetConnectionByKey(token connection.TokenHandler, key string) client.ClientHandler {
+	c, _ := this.acquire(context.Background(), token, key, false)
+	return c
 }
 
 func (this *connpool) ProcessResponse(handler client.ClientHandler, n int, b []byte) {
-	this.idlec_mu.Lock()
-	this.hook_mu.Lock()
-	defer this.hook_mu.Unlock()
-	defer this.idlec_mu.Unlock()
-	token := this.hook[handler]
-	delete(this.hook, handler)
-	this.idlec[handler] = struct{}{}
-	token.Write(b)
+	this.mu.Lock()
+	var token connection.TokenHandler
+	be, ok := this.owner[handler]
+	if ok {
+		token, ok = be.hook[handler]
+	}
+	this.mu.Unlock()
+	if ok {
+		this.RecyclingConnection(handler)
+		if token != nil {
+			token.Write(b)
+		}
+	}
 }
 
 func (this *connpool) ProcessClose(handler client.ClientHandler) {
-	delete(this.idlec, handler)
+	this.mu.Lock()
+	b, ok := this.owner[handler]
+	delete(this.owner, handler)
+	closed := this.closed
+	if ok {
+		delete(b.idlec, handler)
+		delete(b.hook, handler)
+		b.pending -= b.inflight[handler]
+		delete(b.inflight, handler)
+		if !closed && !b.removed {
+			b.lost++
+			b.record(ErrConnectionClosed)
+		}
+	}
+	if !closed && this.waiters.Len() > 0 {
+		//	空出的名额优先补给排队的调用者	MinSize以上的部分不会由后台补充
+		this.dialForWaiters("")
+	}
+	this.mu.Unlock()
+
+	if !closed {
+		select {
+		case this.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (this *connpool) Stats() PoolStats {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	res := PoolStats{}
+	for _, b := range this.backends {
+		s := b.stats()
+		res.Idle += s.Idle
+		res.Busy += s.Busy
+		res.Shared += s.Shared
+		res.Dialing += s.Dialing
+		res.Inflight += s.Inflight
+		res.Failed += s.Failed
+		res.Lost += s.Lost
+		res.Unhealthy += s.Unhealthy
+	}
+	return res
+}
+
+func (this *connpool) Backends() []BackendStats {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	res := make([]BackendStats, 0, len(this.backends))
+	for _, b := range this.backends {
+		res = append(res, b.stats())
+	}
+	return res
+}
+
+// 配置只在创建时规范化一次	之后不再修改
+func newConnpool(opts PoolOptions) *connpool {
+	cp := &connpool{opts: opts}
+	cp.opts.normalize()
+	cp.init()
+	return cp
 }
 
 func New(address string, count int) ConnectionPoolHandler {
-	cp := connpool{}
+	cp := newConnpool(PoolOptions{MinSize: count, MaxSize: count})
 	cp.Connect(address, count)
-	return &cp
+	return cp
+}
+
+// 使用指定的拨号参数(超时、自定义拨号器、TCP选项)建立连接池
+func NewWithDialOptions(address string, count int, opts client.DialOptions) ConnectionPoolHandler {
+	return NewWithOptions(address, PoolOptions{MinSize: count, MaxSize: count, Dial: opts})
+}
+
+// 按配置建立连接池	后台维持MinSize个连接并定期做健康检查
+func NewWithOptions(address string, opts PoolOptions) ConnectionPoolHandler {
+	cp := newConnpool(opts)
+	cp.Connect(address, opts.MinSize)
+	return cp
+}
+
+// 对多个后端建立连接池	weights 为nil时所有后端权重为1
+func NewMulti(addresses []string, weights []int, opts PoolOptions) ConnectionPoolHandler {
+	cp := newConnpool(opts)
+	for i, addr := range addresses {
+		weight := 1
+		if i < len(weights) {
+			weight = weights[i]
+		}
+		cp.AddBackend(addr, weight)
+	}
+	return cp
 }
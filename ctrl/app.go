@@ -0,0 +1,322 @@
+package ctrl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DEFAULT_START_TIMEOUT = 30 * time.Second
+	DEFAULT_STOP_TIMEOUT  = 30 * time.Second
+)
+
+var ErrAppStarted = errors.New("ctrl: app already started")
+
+// 由App管理生命周期的组件
+// Start 应在完成初始化后返回	后台工作放到goroutine中	Stop 应在ctx结束前释放资源
+type Component interface {
+	Start(ctx context.Context) error
+	Stop(ctx context.Context) error
+}
+
+// 用函数组成Component	为nil的函数视为成功
+type Hooks struct {
+	OnStart func(ctx context.Context) error
+	OnStop  func(ctx context.Context) error
+}
+
+func (this Hooks) Start(ctx context.Context) error {
+	if this.OnStart == nil {
+		return nil
+	}
+	return this.OnStart(ctx)
+}
+
+func (this Hooks) Stop(ctx context.Context) error {
+	if this.OnStop == nil {
+		return nil
+	}
+	return this.OnStop(ctx)
+}
+
+// 组件配置	零值字段使用App的默认值
+type ComponentOptions struct {
+	DependsOn    []string //	依赖的组件	先于本组件启动	晚于本组件停止
+	StartTimeout time.Duration
+	StopTimeout  time.Duration
+}
+
+// 多个错误的集合
+type MultiError []error
+
+func (this MultiError) Error() string {
+	msgs := make([]string, 0, len(this))
+	for _, err := range this {
+		msgs = append(msgs, err.Error())
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// 只有一个错误时直接返回它
+func (this MultiError) err() error {
+	switch len(this) {
+	case 0:
+		return nil
+	case 1:
+		return this[0]
+	}
+	return this
+}
+
+type component struct {
+	name string
+	impl Component
+	opts ComponentOptions
+}
+
+// 应用生命周期管理
+// 按依赖顺序启动组件	收到终止信号或Shutdown时按相反顺序停止	返回所有错误的集合
+type App struct {
+	name string
+
+	mu         sync.Mutex
+	components []*component
+	index      map[string]*component
+	started    []*component //	已启动的组件	按启动顺序
+	running    bool
+	stopped    bool //	Stop之后再次Start时重新创建Group
+
+	group    *Group
+	shutdown chan struct{}
+
+	unregister func() //	注销运行状态
+}
+
+// 注册组件	需在Start之前调用
+func (this *App) Register(name string, c Component, opts ComponentOptions) error {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	if this.running {
+		return ErrAppStarted
+	}
+	if _, ok := this.index[name]; ok {
+		return fmt.Errorf("ctrl: component %s registered twice", name)
+	}
+	if opts.StartTimeout <= 0 {
+		opts.StartTimeout = DEFAULT_START_TIMEOUT
+	}
+	if opts.StopTimeout <= 0 {
+		opts.StopTimeout = DEFAULT_STOP_TIMEOUT
+	}
+	comp := &component{name, c, opts}
+	this.components = append(this.components, comp)
+	this.index[name] = comp
+	return nil
+}
+
+// 组件后台goroutine使用的Group	所有组件停止后取消	再次Start时换成新的Group
+func (this *App) Group() *Group {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	return this.group
+}
+
+// 按依赖排序	同一层按注册顺序	调用者持有锁
+func (this *App) order() ([]*component, error) {
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make(map[string]int, len(this.components))
+	res := make([]*component, 0, len(this.components))
+	var visit func(c *component, path []string) error
+	visit = func(c *component, path []string) error {
+		switch state[c.name] {
+		case visiting:
+			return fmt.Errorf("ctrl: dependency cycle %s -> %s", strings.Join(path, " -> "), c.name)
+		case visited:
+			return nil
+		}
+		state[c.name] = visiting
+		for _, dep := range c.opts.DependsOn {
+			d, ok := this.index[dep]
+			if !ok {
+				return fmt.Errorf("ctrl: component %s depends on unknown %s", c.name, dep)
+			}
+			if err := visit(d, append(path, c.name)); err != nil {
+				return err
+			}
+		}
+		state[c.name] = visited
+		res = append(res, c)
+		return nil
+	}
+	for _, c := range this.components {
+		if err := visit(c, nil); err != nil {
+			return nil, err
+		}
+	}
+	return res, nil
+}
+
+// 在超时内执行组件的Start或Stop	超时后不再等待	panic连同调用栈上报后作为错误返回
+func runWithTimeout(ctx context.Context, name string, timeout time.Duration, f func(ctx context.Context) error) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				stack := debug.Stack()
+				ReportPanic(name, r, stack)
+				done <- &PanicError{Name: name, Value: r, Stack: stack}
+			}
+		}()
+		done <- f(ctx)
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// 按依赖顺序启动所有组件	某个组件失败时停止已启动的组件并返回错误
+func (this *App) Start(ctx context.Context) error {
+	this.mu.Lock()
+	if this.running {
+		this.mu.Unlock()
+		return ErrAppStarted
+	}
+	order, err := this.order()
+	if err != nil {
+		this.mu.Unlock()
+		return err
+	}
+	if this.stopped {
+		//	上次Stop已取消旧的Group
+		this.group = NewGroup(this.name)
+		this.shutdown = make(chan struct{})
+		this.stopped = false
+	}
+	this.running = true
+	this.unregister = RegisterStats("App "+this.name, this.stats)
+	this.mu.Unlock()
+
+	for _, c := range order {
+		start := time.Now()
+		if err := runWithTimeout(ctx, this.name+"/"+c.name+".Start", c.opts.StartTimeout, c.impl.Start); err != nil {
+			err = fmt.Errorf("start %s: %s", c.name, err.Error())
+			log.Printf("App %s: %s.\n", this.name, err.Error())
+			//	失败或超时的组件可能已经部分初始化	与已启动的组件一起停止
+			this.mu.Lock()
+			this.started = append(this.started, c)
+			this.mu.Unlock()
+			errs := MultiError{err}
+			if stopErr := this.Stop(); stopErr != nil {
+				errs = append(errs, stopErr)
+			}
+			return errs.err()
+		}
+		this.mu.Lock()
+		this.started = append(this.started, c)
+		this.mu.Unlock()
+		log.Printf("App %s: start %s in %s.\n", this.name, c.name, time.Since(start).Round(time.Millisecond))
+	}
+	return nil
+}
+
+// 按启动的相反顺序停止组件	每个组件有独立的超时	返回所有错误
+func (this *App) Stop() error {
+	this.mu.Lock()
+	started := this.started
+	this.started = nil
+	this.running = false
+	this.stopped = true
+	group := this.group
+	if this.unregister != nil {
+		this.unregister()
+		this.unregister = nil
+	}
+	this.mu.Unlock()
+
+	errs := MultiError{}
+	for i := len(started) - 1; i >= 0; i-- {
+		c := started[i]
+		begin := time.Now()
+		if err := runWithTimeout(context.Background(), this.name+"/"+c.name+".Stop", c.opts.StopTimeout, c.impl.Stop); err != nil {
+			err = fmt.Errorf("stop %s: %s", c.name, err.Error())
+			log.Printf("App %s: %s.\n", this.name, err.Error())
+			errs = append(errs, err)
+			continue
+		}
+		log.Printf("App %s: stop %s in %s.\n", this.name, c.name, time.Since(begin).Round(time.Millisecond))
+	}
+	group.Cancel()
+	if err := group.Wait(DEFAULT_STOP_TIMEOUT); err != nil {
+		errs = append(errs, err)
+	}
+	return errs.err()
+}
+
+func (this *App) stats() string {
+	this.mu.Lock()
+	n := len(this.started)
+	group := this.group
+	this.mu.Unlock()
+	running := group.Running()
+	return fmt.Sprintf("%d/%d components started, %d goroutines %s", n, len(this.components), len(running), strings.Join(running, " "))
+}
+
+// 请求Run停止
+func (this *App) Shutdown() {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	select {
+	case <-this.shutdown:
+	default:
+		close(this.shutdown)
+	}
+}
+
+// 启动所有组件	等待终止信号、Shutdown或ctx结束后停止	返回启动与停止过程中的所有错误
+// 启动前订阅终止信号	启动期间收到信号时取消传给Start的ctx	不必等到所有组件启动完成
+func (this *App) Run(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	sub := OnTerminate(func(sig os.Signal) {
+		log.Printf("App %s: receive %s, stopping.\n", this.name, sig)
+		cancel()
+		this.Shutdown()
+	})
+	defer sub.Cancel()
+	if err := this.Start(ctx); err != nil {
+		return err
+	}
+	this.mu.Lock()
+	shutdown := this.shutdown
+	this.mu.Unlock()
+	select {
+	case <-shutdown:
+	case <-ctx.Done():
+	}
+	return this.Stop()
+}
+
+func NewApp(name string) *App {
+	return &App{
+		name:     name,
+		index:    make(map[string]*component),
+		group:    NewGroup(name),
+		shutdown: make(chan struct{}),
+	}
+}
@@ -0,0 +1,340 @@
+package ctrl
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"runtime/debug"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 重启策略
+type RestartPolicy int
+
+const (
+	RESTART_NEVER    RestartPolicy = iota //	不重启
+	RESTART_ON_PANIC                      //	panic后重启
+	RESTART_ON_ERROR                      //	panic或返回error后重启
+	RESTART_ALWAYS                        //	任何原因退出都重启	直到Group被取消
+
+	DEFAULT_RESTART_BACKOFF = time.Second
+)
+
+// 受监管的goroutine	ctx被取消时应尽快返回
+type GroupFunc func(ctx context.Context) error
+
+// panic处理	stack为panic时的调用栈
+type PanicHandler func(name string, value interface{}, stack []byte)
+
+// 启动参数
+type GoOptions struct {
+	Restart     RestartPolicy
+	MaxRestarts int           //	最多重启次数	<=0 表示不限制
+	Backoff     time.Duration //	两次重启之间的等待时间
+}
+
+// goroutine中发生的panic
+type PanicError struct {
+	Name  string
+	Value interface{}
+	Stack []byte
+}
+
+func (this *PanicError) Error() string {
+	return fmt.Sprintf("ctrl: goroutine %s panic: %v", this.Name, this.Value)
+}
+
+// Wait超时	Running为仍未退出的goroutine
+type WaitTimeoutError struct {
+	Running []string
+}
+
+func (this *WaitTimeoutError) Error() string {
+	return fmt.Sprintf("ctrl: wait timeout, %d goroutines still running: %s", len(this.Running), strings.Join(this.Running, ", "))
+}
+
+func defaultPanicHandler(name string, value interface{}, stack []byte) {
+	log.Printf("Goroutine %s panic: %v\n%s", name, value, stack)
+}
+
+var panic_handler PanicHandler = defaultPanicHandler
+var panic_mu sync.Mutex
+
+// 设置全局的panic处理	没有单独设置处理函数的Group与ReportPanic使用它
+func SetPanicHandler(h PanicHandler) {
+	panic_mu.Lock()
+	defer panic_mu.Unlock()
+	if h == nil {
+		h = defaultPanicHandler
+	}
+	panic_handler = h
+}
+
+// 上报在其他地方recover到的panic
+func ReportPanic(name string, value interface{}, stack []byte) {
+	panic_mu.Lock()
+	h := panic_handler
+	panic_mu.Unlock()
+	h(name, value, stack)
+}
+
+// 一组受监管的goroutine
+// 有名字	共享一个可取消的context	子Group随父Group一起取消	panic会被捕获并上报
+type Group struct {
+	name   string
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu       sync.Mutex
+	wg       sync.WaitGroup
+	running  map[uint64]string
+	next     uint64
+	children []*Group
+	on_panic PanicHandler
+	err      error
+	waiting  chan struct{} //	带超时的Wait共用的等待	所有goroutine退出时关闭
+}
+
+func (this *Group) Name() string {
+	return this.name
+}
+
+func (this *Group) Context() context.Context {
+	return this.ctx
+}
+
+// 取消Group与所有子Group	goroutine需自行检查ctx并退出
+func (this *Group) Cancel() {
+	this.cancel()
+}
+
+// 设置该Group的panic处理	子Group在创建时继承
+func (this *Group) SetPanicHandler(h PanicHandler) {
+	this.mu.Lock()
+	this.on_panic = h
+	this.mu.Unlock()
+}
+
+// 创建子Group	名字为 父名字/name
+// 子Group被取消且所有goroutine退出后从父Group中移除	它的错误保留在父Group的Err中
+func (this *Group) Child(name string) *Group {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	child := newGroup(this.ctx, this.name+"/"+name)
+	child.on_panic = this.on_panic
+	this.children = append(this.children, child)
+	go func() {
+		<-child.ctx.Done()
+		child.wait()
+		this.removeChild(child)
+	}()
+	return child
+}
+
+func (this *Group) removeChild(child *Group) {
+	err := child.Err()
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	if err != nil && this.err == nil {
+		this.err = err
+	}
+	for i, c := range this.children {
+		if c == child {
+			this.children = append(this.children[:i], this.children[i+1:]...)
+			break
+		}
+	}
+}
+
+// 启动一个不重启的goroutine
+func (this *Group) Go(name string, f GroupFunc) {
+	this.GoWithOptions(name, GoOptions{}, f)
+}
+
+func (this *Group) GoWithOptions(name string, opts GoOptions, f GroupFunc) {
+	if opts.Backoff <= 0 {
+		opts.Backoff = DEFAULT_RESTART_BACKOFF
+	}
+	this.mu.Lock()
+	this.next++
+	id := this.next
+	this.running[id] = name
+	this.mu.Unlock()
+
+	//	在启动goroutine之前计数	避免与Wait竞争
+	this.wg.Add(1)
+	go func() {
+		defer func() {
+			this.mu.Lock()
+			delete(this.running, id)
+			this.mu.Unlock()
+			this.wg.Done()
+		}()
+		this.supervise(name, opts, f)
+	}()
+}
+
+func (this *Group) supervise(name string, opts GoOptions, f GroupFunc) {
+	for restarts := 0; ; restarts++ {
+		err := this.call(name, f)
+		if this.ctx.Err() != nil {
+			return
+		}
+		_, panicked := err.(*PanicError)
+		restart := false
+		switch opts.Restart {
+		case RESTART_ON_PANIC:
+			restart = panicked
+		case RESTART_ON_ERROR:
+			restart = err != nil
+		case RESTART_ALWAYS:
+			restart = true
+		}
+		if !restart || (opts.MaxRestarts > 0 && restarts >= opts.MaxRestarts) {
+			if err != nil {
+				this.setErr(err)
+			}
+			return
+		}
+		if err != nil {
+			log.Printf("Group %s: restart %s after error. %s.\n", this.name, name, err.Error())
+		} else {
+			log.Printf("Group %s: restart %s.\n", this.name, name)
+		}
+		timer := time.NewTimer(opts.Backoff)
+		select {
+		case <-this.ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+	}
+}
+
+// 执行一次	panic转换为PanicError
+func (this *Group) call(name string, f GroupFunc) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			stack := debug.Stack()
+			qualified := this.name + "/" + name
+			this.mu.Lock()
+			h := this.on_panic
+			this.mu.Unlock()
+			if h != nil {
+				h(qualified, r, stack)
+			} else {
+				ReportPanic(qualified, r, stack)
+			}
+			err = &PanicError{Name: qualified, Value: r, Stack: stack}
+		}
+	}()
+	return f(this.ctx)
+}
+
+func (this *Group) setErr(err error) {
+	this.mu.Lock()
+	if this.err == nil {
+		this.err = err
+	}
+	this.mu.Unlock()
+}
+
+// 第一个未被重启处理的错误	包括子Group
+func (this *Group) Err() error {
+	this.mu.Lock()
+	err := this.err
+	children := append([]*Group(nil), this.children...)
+	this.mu.Unlock()
+	for _, c := range children {
+		if err != nil {
+			break
+		}
+		err = c.Err()
+	}
+	return err
+}
+
+// 仍在运行的goroutine	名字带有Group前缀	包括子Group
+func (this *Group) Running() []string {
+	this.mu.Lock()
+	res := make([]string, 0, len(this.running))
+	for _, name := range this.running {
+		res = append(res, this.name+"/"+name)
+	}
+	children := append([]*Group(nil), this.children...)
+	this.mu.Unlock()
+	for _, c := range children {
+		res = append(res, c.Running()...)
+	}
+	sort.Strings(res)
+	return res
+}
+
+func (this *Group) wait() {
+	this.wg.Wait()
+	this.mu.Lock()
+	children := append([]*Group(nil), this.children...)
+	this.mu.Unlock()
+	for _, c := range children {
+		c.wait()
+	}
+}
+
+// 等待所有goroutine退出	timeout<=0 表示一直等待	超时返回*WaitTimeoutError
+// 超时后内部的等待goroutine继续运行到所有goroutine退出为止	同一时刻每个Group最多一个	多次超时不会累积
+func (this *Group) Wait(timeout time.Duration) error {
+	if timeout <= 0 {
+		this.wait()
+		return nil
+	}
+	this.mu.Lock()
+	done := this.waiting
+	if done == nil {
+		done = make(chan struct{})
+		this.waiting = done
+		go func() {
+			this.wait()
+			this.mu.Lock()
+			this.waiting = nil
+			this.mu.Unlock()
+			close(done)
+		}()
+	}
+	this.mu.Unlock()
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-done:
+		return nil
+	case <-timer.C:
+		return &WaitTimeoutError{Running: this.Running()}
+	}
+}
+
+// 取消并等待退出
+func (this *Group) Stop(timeout time.Duration) error {
+	this.Cancel()
+	return this.Wait(timeout)
+}
+
+func newGroup(ctx context.Context, name string) *Group {
+	ctx, cancel := context.WithCancel(ctx)
+	return &Group{
+		name:    name,
+		ctx:     ctx,
+		cancel:  cancel,
+		running: make(map[uint64]string),
+	}
+}
+
+func NewGroup(name string) *Group {
+	return newGroup(context.Background(), name)
+}
+
+// ctx结束时Group随之取消
+func NewGroupContext(ctx context.Context, name string) *Group {
+	return newGroup(ctx, name)
+}
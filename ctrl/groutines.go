@@ -3,6 +3,7 @@ package ctrl
 import (
 	"sync"
 	"log"
+	"runtime/debug"
 )
 
 var goroutines_wait *sync.WaitGroup
@@ -22,11 +23,18 @@ func GlobalWaitGroup()*sync.WaitGroup{
 
 type Closure func()
 
+// 启动一个计入全局WaitGroup的goroutine	panic会被捕获并通过ReportPanic上报
+// 新代码请使用Group	它提供名字、取消与重启
 func StartGoroutines(f Closure){
+	goroutines_wait.Add(1)	//	在goroutine启动前计数	避免与Wait竞争
 	go func() {
-		goroutines_wait.Add(1)
+		defer func() {
+			if r := recover(); r != nil {
+				ReportPanic("StartGoroutines", r, debug.Stack())
+			}
+			goroutines_wait.Done()
+		}()
 		f()
-		goroutines_wait.Done()
 	}()
 }
 
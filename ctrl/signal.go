@@ -2,24 +2,167 @@ package ctrl
 
 import (
 	"os"
-	"sync"
 	"os/signal"
+	"runtime/debug"
+	"sync"
 	"syscall"
 )
 
+const (
+	SIGNAL_BUFFER = 8 //	每个信号与每个订阅者的缓冲	处理不过来时合并重复的信号
+)
 
 var signal_exit chan os.Signal
 
 var one sync.Once
 
-func GlobalExitChan()<-chan os.Signal{
+// 终止信号	SIGKILL无法捕获	不在其中
+func GlobalExitChan() <-chan os.Signal {
 	one.Do(func() {
-		signal_exit = make(chan os.Signal)
-		signal.Notify(signal_exit,syscall.SIGINT,syscall.SIGKILL,syscall.SIGTERM)
+		signal_exit = make(chan os.Signal, 1)
+		signal.Notify(signal_exit, syscall.SIGINT, syscall.SIGTERM)
 	})
 	return signal_exit
 }
 
-func GlobalCloseExitChan(){
+func GlobalCloseExitChan() {
+	one.Do(func() {
+		signal_exit = make(chan os.Signal, 1)
+	})
+	signal.Stop(signal_exit)
 	close(signal_exit)
-}
\ No newline at end of file
+}
+
+type SignalHandler func(sig os.Signal)
+
+// 信号订阅	每个订阅者有独立的缓冲与goroutine	慢的处理函数不影响其他订阅者
+type Subscription struct {
+	id      uint64
+	sigs    []os.Signal
+	handler SignalHandler
+	queue   chan os.Signal
+	once    sync.Once
+}
+
+// 取消订阅	正在执行的处理函数不受影响
+func (this *Subscription) Cancel() {
+	this.once.Do(func() {
+		dispatcher.remove(this)
+	})
+}
+
+func (this *Subscription) run() {
+	for sig := range this.queue {
+		this.call(sig)
+	}
+}
+
+func (this *Subscription) call(sig os.Signal) {
+	defer func() {
+		if r := recover(); r != nil {
+			ReportPanic("signal handler "+sig.String(), r, debug.Stack())
+		}
+	}()
+	this.handler(sig)
+}
+
+type signalDispatcher struct {
+	mu    sync.Mutex
+	next  uint64
+	subs  map[os.Signal]map[uint64]*Subscription
+	chans map[os.Signal]chan os.Signal
+}
+
+var dispatcher = &signalDispatcher{
+	subs:  make(map[os.Signal]map[uint64]*Subscription),
+	chans: make(map[os.Signal]chan os.Signal),
+}
+
+func (this *signalDispatcher) add(sub *Subscription) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	this.next++
+	sub.id = this.next
+	for _, sig := range sub.sigs {
+		m, ok := this.subs[sig]
+		if !ok {
+			m = make(map[uint64]*Subscription)
+			this.subs[sig] = m
+		}
+		m[sub.id] = sub
+		if _, ok := this.chans[sig]; !ok {
+			ch := make(chan os.Signal, SIGNAL_BUFFER)
+			this.chans[sig] = ch
+			signal.Notify(ch, sig)
+			go this.forward(ch)
+		}
+	}
+}
+
+func (this *signalDispatcher) remove(sub *Subscription) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	for _, sig := range sub.sigs {
+		m := this.subs[sig]
+		delete(m, sub.id)
+		if len(m) == 0 {
+			//	没有订阅者时恢复该信号的默认行为
+			delete(this.subs, sig)
+			if ch, ok := this.chans[sig]; ok {
+				signal.Stop(ch)
+				close(ch)
+				delete(this.chans, sig)
+			}
+		}
+	}
+	//	在锁内关闭	forward不会再向其投递
+	close(sub.queue)
+}
+
+func (this *signalDispatcher) forward(ch chan os.Signal) {
+	for sig := range ch {
+		this.mu.Lock()
+		for _, sub := range this.subs[sig] {
+			select {
+			case sub.queue <- sig:
+			default:
+				//	订阅者积压	同一信号合并
+			}
+		}
+		this.mu.Unlock()
+	}
+}
+
+// 订阅信号	nil信号(当前平台不支持)被忽略
+func OnSignal(handler SignalHandler, sigs ...os.Signal) *Subscription {
+	valid := make([]os.Signal, 0, len(sigs))
+	for _, sig := range sigs {
+		if sig != nil {
+			valid = append(valid, sig)
+		}
+	}
+	sub := &Subscription{sigs: valid, handler: handler, queue: make(chan os.Signal, SIGNAL_BUFFER)}
+	dispatcher.add(sub)
+	go sub.run()
+	return sub
+}
+
+// 重新加载配置	SIGHUP
+func OnReload(f func()) *Subscription {
+	return OnSignal(func(os.Signal) { f() }, SIGNAL_RELOAD)
+}
+
+// 输出运行状态	SIGUSR1
+func OnStatsDump(f func()) *Subscription {
+	return OnSignal(func(os.Signal) { f() }, SIGNAL_STATS)
+}
+
+// 用户自定义	SIGUSR2
+func OnUser2(f func()) *Subscription {
+	return OnSignal(func(os.Signal) { f() }, SIGNAL_USER2)
+}
+
+// 终止	SIGINT/SIGTERM
+func OnTerminate(f func(sig os.Signal)) *Subscription {
+	return OnSignal(f, syscall.SIGINT, syscall.SIGTERM)
+}
@@ -0,0 +1,15 @@
+//go:build !windows
+// +build !windows
+
+package ctrl
+
+import (
+	"os"
+	"syscall"
+)
+
+var (
+	SIGNAL_RELOAD os.Signal = syscall.SIGHUP
+	SIGNAL_STATS  os.Signal = syscall.SIGUSR1
+	SIGNAL_USER2  os.Signal = syscall.SIGUSR2
+)
@@ -0,0 +1,16 @@
+//go:build windows
+// +build windows
+
+package ctrl
+
+import (
+	"os"
+	"syscall"
+)
+
+// Windows没有SIGUSR1/SIGUSR2	订阅它们不会收到通知
+var (
+	SIGNAL_RELOAD os.Signal = syscall.SIGHUP
+	SIGNAL_STATS  os.Signal = nil
+	SIGNAL_USER2  os.Signal = nil
+)
@@ -0,0 +1,60 @@
+package ctrl
+
+import (
+	"log"
+	"runtime"
+	"sort"
+	"sync"
+)
+
+// 返回一行运行状态	例如连接数
+type StatsFunc func() string
+
+var stats_mu sync.Mutex
+var stats_next uint64
+var stats_funcs = make(map[uint64]statsEntry)
+
+type statsEntry struct {
+	name string
+	f    StatsFunc
+}
+
+// 注册运行状态	DumpStats时输出	返回的函数用于注销
+func RegisterStats(name string, f StatsFunc) func() {
+	stats_mu.Lock()
+	stats_next++
+	id := stats_next
+	stats_funcs[id] = statsEntry{name, f}
+	stats_mu.Unlock()
+	return func() {
+		stats_mu.Lock()
+		delete(stats_funcs, id)
+		stats_mu.Unlock()
+	}
+}
+
+// 把goroutine、内存与注册的运行状态写入日志
+func DumpStats() {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	log.Printf("Stats: goroutines %d, heap %d KB, objects %d, gc %d.\n",
+		runtime.NumGoroutine(), mem.HeapAlloc/1024, mem.HeapObjects, mem.NumGC)
+
+	stats_mu.Lock()
+	entries := make([]statsEntry, 0, len(stats_funcs))
+	for _, e := range stats_funcs {
+		entries = append(entries, e)
+	}
+	stats_mu.Unlock()
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].name < entries[j].name
+	})
+	for _, e := range entries {
+		log.Printf("Stats: %s: %s.\n", e.name, e.f())
+	}
+}
+
+// 收到SIGUSR1时输出运行状态
+func EnableStatsDump() *Subscription {
+	return OnStatsDump(DumpStats)
+}
@@ -0,0 +1,121 @@
+package discovery
+
+import (
+	"log"
+	"sort"
+	"sync"
+)
+
+// 后端服务器
+type Backend struct {
+	Address string `json:"address"`
+	Weight  int    `json:"weight"`
+}
+
+// 后端集合变化时以完整集合回调
+type UpdateFunc func(backends []Backend)
+
+// 后端集合的来源
+type Provider interface {
+	//	当前的后端集合
+	Backends() ([]Backend, error)
+
+	//	开始监听	集合变化时回调	同一Provider只能监听一次
+	Watch(callback UpdateFunc) error
+
+	//	停止监听
+	Close()
+}
+
+// 后端集合的使用者	connpool.ConnectionPoolHandler 直接满足该接口	代理使用ProxyTarget包装
+type Target interface {
+	AddBackend(address string, weight int)
+	RemoveBackend(address string)
+}
+
+// 把Provider的后端集合同步到多个Target	只下发增删与权重变化
+type Syncer struct {
+	provider Provider
+	targets  []Target
+
+	mu      sync.Mutex
+	current map[string]Backend
+}
+
+// 规范化后端集合	去掉空地址	重复地址以最后一个为准	按地址排序
+func normalize(backends []Backend) []Backend {
+	m := make(map[string]Backend, len(backends))
+	for _, b := range backends {
+		if b.Address == "" {
+			continue
+		}
+		if b.Weight < 1 {
+			b.Weight = 1
+		}
+		m[b.Address] = b
+	}
+	res := make([]Backend, 0, len(m))
+	for _, b := range m {
+		res = append(res, b)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Address < res[j].Address
+	})
+	return res
+}
+
+func (this *Syncer) apply(backends []Backend) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	next := make(map[string]Backend, len(backends))
+	for _, b := range normalize(backends) {
+		next[b.Address] = b
+		if old, ok := this.current[b.Address]; ok && old.Weight == b.Weight {
+			continue
+		}
+		log.Printf("Discovery: add backend %s weight %d.\n", b.Address, b.Weight)
+		for _, t := range this.targets {
+			t.AddBackend(b.Address, b.Weight)
+		}
+	}
+	for addr := range this.current {
+		if _, ok := next[addr]; ok {
+			continue
+		}
+		log.Printf("Discovery: remove backend %s.\n", addr)
+		for _, t := range this.targets {
+			t.RemoveBackend(addr)
+		}
+	}
+	this.current = next
+}
+
+// 当前已同步的后端集合
+func (this *Syncer) Backends() []Backend {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	res := make([]Backend, 0, len(this.current))
+	for _, b := range this.current {
+		res = append(res, b)
+	}
+	return normalize(res)
+}
+
+// 停止同步	已下发的后端保持不变
+func (this *Syncer) Close() {
+	this.provider.Close()
+}
+
+// 立即同步一次当前集合并开始监听变化
+func Sync(provider Provider, targets ...Target) (*Syncer, error) {
+	s := &Syncer{provider: provider, targets: targets, current: make(map[string]Backend)}
+	backends, err := provider.Backends()
+	if err != nil {
+		return nil, err
+	}
+	s.apply(backends)
+	if err := provider.Watch(s.apply); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
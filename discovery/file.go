@@ -0,0 +1,293 @@
+package discovery
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"wwt/ctrl"
+)
+
+const (
+	DEFAULT_POLL_INTERVAL = 2 * time.Second
+)
+
+var ErrAlreadyWatching = errors.New("discovery: provider is already watching")
+
+// 文件中没有后端列表	通常是键名写错或文件不完整	不当作空集合	以免移除所有后端
+var ErrNoBackendList = errors.New("discovery: missing backends list")
+
+// 从本地JSON/YAML文件读取后端集合	定期检查文件变化并重新加载
+//
+// JSON格式:
+//
+//	{"backends": [{"address": "10.0.0.1:9000", "weight": 2}, "10.0.0.2:9000"]}
+//
+// YAML格式:
+//
+//	backends:
+//	  - address: 10.0.0.1:9000
+//	    weight: 2
+//	  - 10.0.0.2:9000
+//
+// 顶层也可以直接是后端列表	文件解析失败或缺少backends时保留上一次的集合
+type FileProvider struct {
+	path     string
+	interval time.Duration
+
+	mu       sync.Mutex
+	mod_time time.Time
+	size     int64
+	last     []Backend
+	callback UpdateFunc
+
+	exit       chan struct{}
+	close_once sync.Once
+}
+
+func (this *FileProvider) Backends() ([]Backend, error) {
+	backends, err := this.load()
+	if err != nil {
+		return nil, err
+	}
+	this.mu.Lock()
+	this.last = backends
+	this.mu.Unlock()
+	return backends, nil
+}
+
+func (this *FileProvider) load() ([]Backend, error) {
+	info, err := os.Stat(this.path)
+	if err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadFile(this.path)
+	if err != nil {
+		return nil, err
+	}
+	backends, err := Parse(this.path, data)
+	if err != nil {
+		return nil, err
+	}
+	this.mu.Lock()
+	this.mod_time = info.ModTime()
+	this.size = info.Size()
+	this.mu.Unlock()
+	return backends, nil
+}
+
+func (this *FileProvider) Watch(callback UpdateFunc) error {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	if this.callback != nil {
+		return ErrAlreadyWatching
+	}
+	this.callback = callback
+	ctrl.StartGoroutines(func() {
+		this.poll()
+	})
+	return nil
+}
+
+func (this *FileProvider) poll() {
+	ticker := time.NewTicker(this.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-this.exit:
+			return
+		case <-ticker.C:
+			this.check()
+		}
+	}
+}
+
+// 文件有变化时重新加载	集合不变时不回调
+func (this *FileProvider) check() {
+	info, err := os.Stat(this.path)
+	if err != nil {
+		//	文件被替换的瞬间可能不存在	等下一次检查
+		return
+	}
+	this.mu.Lock()
+	changed := !info.ModTime().Equal(this.mod_time) || info.Size() != this.size
+	this.mu.Unlock()
+	if !changed {
+		return
+	}
+	backends, err := this.load()
+	if err != nil {
+		log.Printf("Discovery: reload %s fail. %s.\n", this.path, err.Error())
+		return
+	}
+	this.mu.Lock()
+	if reflect.DeepEqual(backends, this.last) {
+		this.mu.Unlock()
+		return
+	}
+	this.last = backends
+	callback := this.callback
+	this.mu.Unlock()
+	log.Printf("Discovery: reload %s, %d backends.\n", this.path, len(backends))
+	if callback != nil {
+		callback(backends)
+	}
+}
+
+func (this *FileProvider) Close() {
+	this.close_once.Do(func() {
+		close(this.exit)
+	})
+}
+
+// interval<=0 时使用默认的检查间隔
+func NewFileProvider(path string, interval time.Duration) *FileProvider {
+	if interval <= 0 {
+		interval = DEFAULT_POLL_INTERVAL
+	}
+	return &FileProvider{path: path, interval: interval, exit: make(chan struct{})}
+}
+
+// 按扩展名解析后端集合	.yaml/.yml 按YAML解析	其余按JSON解析
+func Parse(path string, data []byte) ([]Backend, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return ParseYAML(data)
+	}
+	return ParseJSON(data)
+}
+
+func ParseJSON(data []byte) ([]Backend, error) {
+	var list []json.RawMessage
+	if err := json.Unmarshal(data, &list); err != nil {
+		var doc struct {
+			Backends *[]json.RawMessage `json:"backends"`
+		}
+		if err := json.Unmarshal(data, &doc); err != nil {
+			return nil, err
+		}
+		if doc.Backends == nil {
+			return nil, ErrNoBackendList
+		}
+		list = *doc.Backends
+	}
+	res := make([]Backend, 0, len(list))
+	for _, raw := range list {
+		var addr string
+		if json.Unmarshal(raw, &addr) == nil {
+			res = append(res, Backend{Address: addr})
+			continue
+		}
+		var b Backend
+		if err := json.Unmarshal(raw, &b); err != nil {
+			return nil, err
+		}
+		res = append(res, b)
+	}
+	return normalize(res), nil
+}
+
+// 只支持后端列表所需的YAML子集	列表项为地址或 address/weight 映射
+func ParseYAML(data []byte) ([]Backend, error) {
+	res := make([]Backend, 0)
+	var cur *Backend
+	item_indent := -1
+	found := false //	出现过 backends: 或列表项
+	for i, line := range strings.Split(string(data), "\n") {
+		line = stripComment(line)
+		if strings.TrimSpace(line) == "" || strings.TrimSpace(line) == "---" {
+			continue
+		}
+		indent := len(line) - len(strings.TrimLeft(line, " \t"))
+		text := strings.TrimSpace(line)
+
+		if strings.HasPrefix(text, "-") {
+			if item_indent < 0 {
+				item_indent = indent
+			}
+			found = true
+			if indent != item_indent {
+				return nil, yamlError(i, "unexpected indentation")
+			}
+			if cur != nil {
+				res = append(res, *cur)
+			}
+			cur = &Backend{}
+			text = strings.TrimSpace(text[1:])
+			if text == "" {
+				continue
+			}
+			if !strings.Contains(text, ": ") && !strings.HasSuffix(text, ":") {
+				//	标量列表项	直接是地址
+				cur.Address = unquote(text)
+				continue
+			}
+		} else if cur == nil || indent <= item_indent {
+			//	列表之外的键	只识别 backends:
+			if text == "backends:" {
+				found = true
+				continue
+			}
+			return nil, yamlError(i, "unknown key "+text)
+		}
+
+		k, v, ok := splitKV(text)
+		if !ok {
+			return nil, yamlError(i, "expected key: value")
+		}
+		switch k {
+		case "address", "addr":
+			cur.Address = v
+		case "weight":
+			w, err := strconv.Atoi(v)
+			if err != nil {
+				return nil, yamlError(i, "invalid weight "+v)
+			}
+			cur.Weight = w
+		default:
+			return nil, yamlError(i, "unknown key "+k)
+		}
+	}
+	if cur != nil {
+		res = append(res, *cur)
+	}
+	if !found {
+		return nil, ErrNoBackendList
+	}
+	return normalize(res), nil
+}
+
+func yamlError(line int, msg string) error {
+	return errors.New("discovery: yaml line " + strconv.Itoa(line+1) + ": " + msg)
+}
+
+func stripComment(line string) string {
+	for i := 0; i < len(line); i++ {
+		if line[i] == '#' && (i == 0 || line[i-1] == ' ' || line[i-1] == '\t') {
+			return line[:i]
+		}
+	}
+	return line
+}
+
+func splitKV(text string) (string, string, bool) {
+	idx := strings.Index(text, ":")
+	if idx <= 0 {
+		return "", "", false
+	}
+	return strings.TrimSpace(text[:idx]), unquote(strings.TrimSpace(text[idx+1:])), true
+}
+
+func unquote(s string) string {
+	if len(s) >= 2 && (s[0] == '"' && s[len(s)-1] == '"' || s[0] == '\'' && s[len(s)-1] == '\'') {
+		return s[1 : len(s)-1]
+	}
+	return s
+}
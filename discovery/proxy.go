@@ -0,0 +1,37 @@
+package discovery
+
+import (
+	"sync"
+	"wwt/proxy"
+)
+
+type proxyTarget struct {
+	proxy proxy.ProxyHandle
+	idle  int
+
+	mu    sync.Mutex
+	known map[string]bool
+}
+
+// 代理不区分权重	只在第一次出现时预先建立idle个闲置连接
+func (this *proxyTarget) AddBackend(address string, _ int) {
+	this.mu.Lock()
+	known := this.known[address]
+	this.known[address] = true
+	this.mu.Unlock()
+	if !known {
+		this.proxy.AddBackend(address, this.idle)
+	}
+}
+
+func (this *proxyTarget) RemoveBackend(address string) {
+	this.mu.Lock()
+	delete(this.known, address)
+	this.mu.Unlock()
+	this.proxy.RemoveBackend(address)
+}
+
+// 把代理包装成Target
+func ProxyTarget(p proxy.ProxyHandle, idle int) Target {
+	return &proxyTarget{proxy: p, idle: idle, known: make(map[string]bool)}
+}
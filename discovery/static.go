@@ -0,0 +1,54 @@
+package discovery
+
+import "sync"
+
+// 固定的后端列表	可以通过Update手动替换
+type StaticProvider struct {
+	mu       sync.Mutex
+	backends []Backend
+	callback UpdateFunc
+}
+
+func (this *StaticProvider) Backends() ([]Backend, error) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	return append([]Backend(nil), this.backends...), nil
+}
+
+func (this *StaticProvider) Watch(callback UpdateFunc) error {
+	this.mu.Lock()
+	this.callback = callback
+	this.mu.Unlock()
+	return nil
+}
+
+// 替换后端列表并通知监听者
+func (this *StaticProvider) Update(backends []Backend) {
+	this.mu.Lock()
+	this.backends = normalize(backends)
+	callback := this.callback
+	res := append([]Backend(nil), this.backends...)
+	this.mu.Unlock()
+	if callback != nil {
+		callback(res)
+	}
+}
+
+func (this *StaticProvider) Close() {
+	this.mu.Lock()
+	this.callback = nil
+	this.mu.Unlock()
+}
+
+func NewStatic(backends []Backend) *StaticProvider {
+	return &StaticProvider{backends: normalize(backends)}
+}
+
+// 权重全部为1的地址列表
+func NewStaticAddrs(addrs ...string) *StaticProvider {
+	backends := make([]Backend, 0, len(addrs))
+	for _, addr := range addrs {
+		backends = append(backends, Backend{Address: addr, Weight: 1})
+	}
+	return NewStatic(backends)
+}
@@ -1,19 +1,25 @@
 package client
 
 import (
-	"wwt/util"
+	"context"
 	"log"
 	"net"
-	"wwt/ctrl"
-	"sync"
+	"wwt/net/peer"
+	"wwt/net/rpc"
 )
 
 const (
-	BUFFER_SIZE = 2048
-	RCHAN_SIZE  = 1024
-	WCHAN_SIZE  = 1024
+	BUFFER_SIZE = peer.BUFFER_SIZE
+	RCHAN_SIZE  = peer.RCHAN_SIZE
+	WCHAN_SIZE  = peer.WCHAN_SIZE
+
+	//	Deprecated: 使用 peer.CALL_TIMEOUT
+	CALL_TIMEOUT = peer.CALL_TIMEOUT
 )
 
+// Deprecated: 使用 rpc.ErrCallTimeout
+var ErrCallTimeout = rpc.ErrCallTimeout
+
 type ReadCallback func(ClientHandler, int, []byte)
 type CloseCallback func(ClientHandler)
 type SendCallback func(ClientHandler, []byte, int, error)
@@ -21,197 +27,134 @@ type SendCallback func(ClientHandler, []byte, int, error)
 type ClientHandler interface {
 	Dial(address string, read_callback ReadCallback, close_callbcak CloseCallback) error
 
+	//	可取消、可超时的拨号
+	DialContext(ctx context.Context, address string, read_callback ReadCallback, close_callbcak CloseCallback) error
+
+	//	设置拨号参数	需在Dial之前调用
+	SetDialOptions(opts DialOptions)
+
+	//	设置帧格式	需在Dial之前调用	nil表示默认格式
+	SetFrameCodec(codec peer.FrameCodec)
+
 	StartRead()
 
 	StartSend()
 
 	Write([]byte)
 
+	//	开启请求/应答帧	需在Dial之前调用	未开启时Call返回rpc.ErrRPCDisabled
+	SetRPC(enable bool)
+
+	//	发送请求并等待对应的应答
+	Call(ctx context.Context, payload []byte) ([]byte, error)
+
 	Close()
 
+	IsClosed() bool
+
 	RemoteAddr() net.Addr
 }
 
-type WChan chan []byte
-type RChan chan []byte
+type WChan = peer.WChan
+type RChan = peer.RChan
 
+// 客户端连接	读写逻辑由peer.QPeer实现
 type QClient struct {
-	conn           net.Conn
+	*peer.QPeer
+
 	read_callback  ReadCallback
 	close_callback CloseCallback
 
-	r_exit   chan struct{}
-	r_chan   RChan
-	r_stream util.StreamBuffer
-
-	w_exit chan struct{}
-	w_chan WChan
-
-	task_group sync.WaitGroup
-	close_once sync.Once
+	dial_opts DialOptions
+	codec     peer.FrameCodec
+	recorder  peer.TapFactory
+	rpc       bool
 }
 
-func (this *QClient) Close() {
-	this.close_once.Do(func() {
-		close(this.r_exit) //	关闭对远端数据流的处理		影响到processRead方法		放弃从管道中读入数据并退出
-		close(this.w_exit) //	对上层应用关闭输入口	影响到Write方法	针对准备写入数据时被阻塞的goroutine
-
-		this.conn.Close() //	关闭连接，readAsync,sendAsync会触发异常并退出
+func (this *QClient) SetDialOptions(opts DialOptions) {
+	this.dial_opts = opts
+}
 
-		this.task_group.Wait() //	等待该客户端所有任务	goroutuines	退出
+func (this *QClient) SetFrameCodec(codec peer.FrameCodec) {
+	this.codec = codec
+}
 
-		close(this.r_chan) //	关闭处理数据流管道
-		close(this.w_chan) //	关闭发送数据流管道
-		this.close_callback(this)
-	})
+func (this *QClient) SetRPC(enable bool) {
+	this.rpc = enable
+}
 
+// 录制连接上的每一帧	需在Dial之前调用
+func (this *QClient) SetRecorder(recorder peer.TapFactory) {
+	this.recorder = recorder
 }
 
-func (this *QClient) RemoteAddr() net.Addr {
-	return this.conn.RemoteAddr()
+func (this *QClient) Dial(address string, read_callback ReadCallback, close_callbcak CloseCallback) error {
+	return this.DialContext(context.Background(), address, read_callback, close_callbcak)
 }
 
-func (this *QClient) sendAsync() {
-	defer func() {
-		this.task_group.Done()
-		_ = recover() //	捕获异常	改层易触发conn close 异常
-		this.Close()
-	}()
-
-	for {
-		select {
-		case <-this.w_exit:
-			panic(nil)
-			return
-		case b := <-this.w_chan:
-			if b != nil {
-				stream := util.NewStreamBuffer()
-				stream.WriteInt(len(b))
-				stream.Append(b)
-				n, err := this.conn.Write(stream.Bytes())
-				if n <= 0 || err != nil {
-					panic(err)
-					return
-				}
-			} else {
-				return
-			}
-		}
+func (this *QClient) DialContext(ctx context.Context, address string, read_callback ReadCallback, close_callbcak CloseCallback) error {
+	if err := this.open(ctx, address, read_callback, close_callbcak); err != nil {
+		return err
 	}
+	this.StartRead()
+	this.StartSend()
+	log.Printf("Connect to %s.\n", this.RemoteAddr().String())
+	return nil
 }
 
-func (this *QClient) StartSend() {
-	ctrl.StartGoroutines(func() {
-		this.sendAsync()
-	})
-}
+// 以下方法在Dial之前也可以调用	此时连接视为已关闭
 
-func (this *QClient) Write(b []byte) {
-	defer func() {
-		_ = recover()
-	}()
-	select {
-	case <-this.w_exit:
-		return
-	default:
-		this.w_chan <- b
+func (this *QClient) IsClosed() bool {
+	if this.QPeer == nil {
+		return true
 	}
+	return this.QPeer.IsClosed()
 }
 
-func (this *QClient) Dial(address string, read_callback ReadCallback, close_callbcak CloseCallback) error {
-	conn, err := net.Dial("tcp", address)
-	if err == nil && conn != nil {
-		this.conn = conn
-		this.task_group.Add(3)
-		this.r_exit = make(chan struct{})
-		this.r_chan = make(RChan, RCHAN_SIZE)
-		this.r_stream = util.NewStreamBuffer()
-		this.read_callback = read_callback
-		this.StartRead()
-
-		this.close_callback = close_callbcak
-
-		this.w_exit = make(chan struct{})
-		this.w_chan = make(WChan, WCHAN_SIZE)
-		this.StartSend()
-		log.Printf("Connect to %s.\n", conn.RemoteAddr().String())
+func (this *QClient) RemoteAddr() net.Addr {
+	if this.QPeer == nil {
 		return nil
-	} else {
-		return err
 	}
+	return this.QPeer.RemoteAddr()
 }
 
-func (this *QClient) readAsync() {
-	defer func() {
-		this.task_group.Done()
-		_ = recover()
-		this.Close()
-	}()
-
-	for {
-		select {
-		case <-this.r_exit:
-			panic(nil)
-			return
-		default:
-			b := make([]byte, BUFFER_SIZE)
-			n, err := this.conn.Read(b) //	可引发连接异常
-			if n <= 0 || err != nil {
-				panic(err)
-				return
-			}
-			this.r_chan <- b[:n]
-		}
+func (this *QClient) Close() {
+	if this.QPeer != nil {
+		this.QPeer.Close()
 	}
 }
 
-func (this *QClient) processRead() {
-	defer func() {
-		this.task_group.Done()
-		_ = recover() //	捕获异常
-		//	TODO::Nothing
-	}()
-	for {
-		select {
-		case <-this.r_exit:
-			panic(nil)
-			return
-		case b := <-this.r_chan:
-			if b != nil {
-				this.r_stream.Append(b)
-				for this.r_stream.Len() > 4 {
-					length := this.r_stream.ReadInt()
-					//	数据包已经完整
-					if !this.r_stream.Empty() && length <= this.r_stream.Len() && length > 0 {
-						data := this.r_stream.ReadNBytes(length)
-						this.read_callback(this, length, data)
-					} else if length==0{
-						//	心跳包
-						log.Printf("Heart beat from host: %s.\n",this.RemoteAddr())
-					} else {
-						this.r_stream.Undo()
-						break
-					}
-				}
-			} else {
-				panic(nil)
-				return
-			}
-
-		}
+func (this *QClient) Write(b []byte) {
+	if this.QPeer != nil {
+		this.QPeer.Write(b)
 	}
 }
 
-func (this *QClient) StartRead() {
-
-	ctrl.StartGoroutines(func() {
-		this.readAsync()
-	})
-	ctrl.StartGoroutines(func() {
-		this.processRead()
-	})
+func (this *QClient) Call(ctx context.Context, payload []byte) ([]byte, error) {
+	if this.QPeer == nil {
+		return nil, rpc.ErrConnectionClosed
+	}
+	return this.QPeer.Call(ctx, payload)
 }
 
-func (this *QClient) read(b []byte) (int, error) {
-	return this.conn.Read(b)
+// 建立连接但不启动读写	调用者随后需StartRead与StartSend
+func (this *QClient) open(ctx context.Context, address string, read_callback ReadCallback, close_callbcak CloseCallback) error {
+	conn, err := this.dial_opts.dial(ctx, address)
+	if err != nil {
+		return err
+	}
+	this.read_callback = read_callback
+	this.close_callback = close_callbcak
+	this.QPeer = peer.NewQPeerWithCodec(conn, this.codec, func(n int, b []byte) {
+		this.read_callback(this, n, b)
+	}, func() {
+		this.close_callback(this)
+	})
+	if this.recorder != nil {
+		this.SetFrameTap(this.recorder.Open(conn))
+	}
+	if this.rpc {
+		this.EnableRPC()
+	}
+	return nil
 }
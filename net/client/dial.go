@@ -0,0 +1,95 @@
+package client
+
+import (
+	"context"
+	"net"
+	"time"
+)
+
+const (
+	DEFAULT_NETWORK      = "tcp"
+	DEFAULT_DIAL_TIMEOUT = 10 * time.Second
+	DEFAULT_KEEPALIVE    = 30 * time.Second
+)
+
+// 建立连接的方式	net.Dialer 满足该接口
+// 可以注入自定义实现	例如绑定本地地址、unix socket、内存连接(net.Pipe)
+type Dialer interface {
+	DialContext(ctx context.Context, network, address string) (net.Conn, error)
+}
+
+// 拨号配置	零值字段使用默认值
+type DialOptions struct {
+	Network string        //	默认 tcp
+	Timeout time.Duration //	拨号超时	<0 表示不限制	仅在ctx没有截止时间时生效
+	Dialer  Dialer        //	自定义拨号器	为nil时使用net.Dialer
+
+	KeepAlive   time.Duration //	TCP keepalive 间隔	<0 表示关闭
+	Nagle       bool          //	开启Nagle算法	默认关闭(NoDelay)
+	ReadBuffer  int           //	socket读缓冲区大小	<=0 使用系统默认值
+	WriteBuffer int           //	socket写缓冲区大小	<=0 使用系统默认值
+}
+
+func (this *DialOptions) network() string {
+	if this.Network == "" {
+		return DEFAULT_NETWORK
+	}
+	return this.Network
+}
+
+func (this *DialOptions) dialer() Dialer {
+	if this.Dialer != nil {
+		return this.Dialer
+	}
+	d := &net.Dialer{KeepAlive: this.KeepAlive}
+	if this.KeepAlive == 0 {
+		d.KeepAlive = DEFAULT_KEEPALIVE
+	}
+	return d
+}
+
+func (this *DialOptions) dial(ctx context.Context, address string) (net.Conn, error) {
+	if _, ok := ctx.Deadline(); !ok && this.Timeout >= 0 {
+		timeout := this.Timeout
+		if timeout == 0 {
+			timeout = DEFAULT_DIAL_TIMEOUT
+		}
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	conn, err := this.dialer().DialContext(ctx, this.network(), address)
+	if err != nil {
+		return nil, err
+	}
+	this.apply(conn)
+	return conn, nil
+}
+
+// 设置连接级别的TCP参数	非TCP连接忽略
+func (this *DialOptions) apply(conn net.Conn) {
+	tcp, ok := conn.(*net.TCPConn)
+	if !ok {
+		return
+	}
+	tcp.SetNoDelay(!this.Nagle)
+	if this.KeepAlive < 0 {
+		tcp.SetKeepAlive(false)
+	} else {
+		tcp.SetKeepAlive(true)
+		if this.KeepAlive > 0 {
+			tcp.SetKeepAlivePeriod(this.KeepAlive)
+		}
+	}
+	if this.ReadBuffer > 0 {
+		tcp.SetReadBuffer(this.ReadBuffer)
+	}
+	if this.WriteBuffer > 0 {
+		tcp.SetWriteBuffer(this.WriteBuffer)
+	}
+}
+
+// 建立不分帧的原始连接	用于透传等不走QNet帧格式的场景
+func DialRaw(ctx context.Context, address string, opts DialOptions) (net.Conn, error) {
+	return opts.dial(ctx, address)
+}
@@ -0,0 +1,310 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"log"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+	"wwt/ctrl"
+	"wwt/net/peer"
+	"wwt/net/rpc"
+)
+
+const (
+	DEFAULT_MIN_BACKOFF   = 100 * time.Millisecond
+	DEFAULT_MAX_BACKOFF   = 30 * time.Second
+	DEFAULT_MULTIPLIER    = 2.0
+	DEFAULT_JITTER        = 0.2
+	DEFAULT_WBUFFER_LIMIT = 1024
+)
+
+var ErrReconnectGiveUp = errors.New("client: reconnect retries exhausted")
+
+type DisconnectCallback func(ClientHandler)
+type ReconnectCallback func(ClientHandler, int)
+
+// 自动重连配置	零值字段使用默认值
+type ReconnectOptions struct {
+	MinBackoff time.Duration //	第一次重连前的等待时间
+	MaxBackoff time.Duration //	等待时间上限
+	Multiplier float64       //	每次失败后等待时间的倍数
+	Jitter     float64       //	随机抖动比例	[0,1)
+	MaxRetries int           //	连续失败次数上限	<=0 表示一直重试
+
+	BufferWrites bool //	断线期间缓存写入的数据	重连成功后发送
+	BufferLimit  int  //	缓存的最大帧数	超出时丢弃最旧的数据
+
+	OnDisconnect DisconnectCallback //	连接断开
+	OnReconnect  ReconnectCallback  //	重连成功	参数为本次重连尝试的次数
+}
+
+func (this *ReconnectOptions) normalize() {
+	if this.MinBackoff <= 0 {
+		this.MinBackoff = DEFAULT_MIN_BACKOFF
+	}
+	if this.MaxBackoff < this.MinBackoff {
+		this.MaxBackoff = DEFAULT_MAX_BACKOFF
+		if this.MaxBackoff < this.MinBackoff {
+			this.MaxBackoff = this.MinBackoff
+		}
+	}
+	if this.Multiplier < 1 {
+		this.Multiplier = DEFAULT_MULTIPLIER
+	}
+	if this.Jitter < 0 || this.Jitter >= 1 {
+		this.Jitter = DEFAULT_JITTER
+	}
+	if this.BufferLimit <= 0 {
+		this.BufferLimit = DEFAULT_WBUFFER_LIMIT
+	}
+}
+
+// 断线自动重连的客户端
+// 对上层保持同一个ClientHandler	回调中拿到的始终是QReconnectClient本身
+type QReconnectClient struct {
+	opts ReconnectOptions
+
+	address        string
+	dial_opts      DialOptions
+	codec          peer.FrameCodec
+	recorder       peer.TapFactory
+	rpc            bool
+	read_callback  ReadCallback
+	close_callback CloseCallback
+
+	mu      sync.Mutex
+	current *QClient
+	wbuffer [][]byte
+
+	exit       chan struct{}
+	close_once sync.Once
+}
+
+func (this *QReconnectClient) SetDialOptions(opts DialOptions) {
+	this.dial_opts = opts
+}
+
+func (this *QReconnectClient) SetFrameCodec(codec peer.FrameCodec) {
+	this.codec = codec
+}
+
+func (this *QReconnectClient) SetRPC(enable bool) {
+	this.rpc = enable
+}
+
+// 每次重连建立的连接都会被录制
+func (this *QReconnectClient) SetRecorder(recorder peer.TapFactory) {
+	this.recorder = recorder
+}
+
+func (this *QReconnectClient) Dial(address string, read_callback ReadCallback, close_callbcak CloseCallback) error {
+	return this.DialContext(context.Background(), address, read_callback, close_callbcak)
+}
+
+func (this *QReconnectClient) DialContext(ctx context.Context, address string, read_callback ReadCallback, close_callbcak CloseCallback) error {
+	this.address = address
+	this.read_callback = read_callback
+	this.close_callback = close_callbcak
+	return this.connect(ctx)
+}
+
+func (this *QReconnectClient) connect(ctx context.Context) error {
+	c := &QClient{}
+	c.SetDialOptions(this.dial_opts)
+	c.SetFrameCodec(this.codec)
+	c.SetRecorder(this.recorder)
+	c.SetRPC(this.rpc)
+	err := c.open(ctx, this.address, this.onRead, this.onInnerClose)
+	if err != nil {
+		return err
+	}
+	this.mu.Lock()
+	select {
+	case <-this.exit:
+		//	重连期间上层已关闭
+		this.mu.Unlock()
+		c.StartRead()
+		c.StartSend()
+		c.Close()
+		return rpc.ErrConnectionClosed
+	default:
+	}
+	//	先记录当前连接再启动读写	连接随即断开时onInnerClose也能识别出是当前连接
+	this.current = c
+	buffered := this.wbuffer
+	this.wbuffer = nil
+	this.mu.Unlock()
+
+	c.StartRead()
+	c.StartSend()
+	log.Printf("Connect to %s.\n", c.RemoteAddr().String())
+	for _, b := range buffered {
+		c.Write(b)
+	}
+	return nil
+}
+
+func (this *QReconnectClient) onRead(_ ClientHandler, n int, b []byte) {
+	this.read_callback(this, n, b)
+}
+
+func (this *QReconnectClient) onInnerClose(c ClientHandler) {
+	this.mu.Lock()
+	if this.current != c {
+		//	不是当前连接	已被上层关闭或被新连接取代
+		this.mu.Unlock()
+		return
+	}
+	this.current = nil
+	this.mu.Unlock()
+
+	select {
+	case <-this.exit:
+		return
+	default:
+	}
+
+	log.Printf("QReconnectClient %p: Lost connection to %s.\n", this, this.address)
+	if this.opts.OnDisconnect != nil {
+		this.opts.OnDisconnect(this)
+	}
+	ctrl.StartGoroutines(func() {
+		this.reconnect()
+	})
+}
+
+func (this *QReconnectClient) backoff(attempt int) time.Duration {
+	d := float64(this.opts.MinBackoff)
+	for i := 1; i < attempt && d < float64(this.opts.MaxBackoff); i++ {
+		d *= this.opts.Multiplier
+	}
+	if d > float64(this.opts.MaxBackoff) {
+		d = float64(this.opts.MaxBackoff)
+	}
+	//	在 [d*(1-jitter), d*(1+jitter)] 之间随机
+	d += d * this.opts.Jitter * (rand.Float64()*2 - 1)
+	return time.Duration(d)
+}
+
+func (this *QReconnectClient) reconnect() {
+	for attempt := 1; this.opts.MaxRetries <= 0 || attempt <= this.opts.MaxRetries; attempt++ {
+		timer := time.NewTimer(this.backoff(attempt))
+		select {
+		case <-this.exit:
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		err := this.connect(context.Background())
+		if err == nil {
+			log.Printf("QReconnectClient %p: Reconnect to %s after %d attempts.\n", this, this.address, attempt)
+			if this.opts.OnReconnect != nil {
+				this.opts.OnReconnect(this, attempt)
+			}
+			return
+		}
+		if err == rpc.ErrConnectionClosed {
+			return
+		}
+		log.Printf("QReconnectClient %p: Reconnect attempt %d fail. %s.\n", this, attempt, err.Error())
+	}
+	log.Printf("QReconnectClient %p: %s.\n", this, ErrReconnectGiveUp.Error())
+	this.Close()
+}
+
+func (this *QReconnectClient) conn() *QClient {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	return this.current
+}
+
+func (this *QReconnectClient) StartRead() {
+	if c := this.conn(); c != nil {
+		c.StartRead()
+	}
+}
+
+func (this *QReconnectClient) StartSend() {
+	if c := this.conn(); c != nil {
+		c.StartSend()
+	}
+}
+
+func (this *QReconnectClient) Write(b []byte) {
+	this.mu.Lock()
+	c := this.current
+	if c == nil {
+		select {
+		case <-this.exit:
+		default:
+			if this.opts.BufferWrites {
+				if len(this.wbuffer) >= this.opts.BufferLimit {
+					this.wbuffer = this.wbuffer[1:]
+				}
+				this.wbuffer = append(this.wbuffer, b)
+			}
+		}
+		this.mu.Unlock()
+		return
+	}
+	this.mu.Unlock()
+	c.Write(b)
+}
+
+func (this *QReconnectClient) Call(ctx context.Context, payload []byte) ([]byte, error) {
+	c := this.conn()
+	if c == nil {
+		return nil, rpc.ErrConnectionClosed
+	}
+	return c.Call(ctx, payload)
+}
+
+func (this *QReconnectClient) RemoteAddr() net.Addr {
+	if c := this.conn(); c != nil {
+		return c.RemoteAddr()
+	}
+	return nil
+}
+
+// 是否已被上层关闭或放弃重连	断线重连期间返回false
+func (this *QReconnectClient) IsClosed() bool {
+	select {
+	case <-this.exit:
+		return true
+	default:
+		return false
+	}
+}
+
+// 当前是否处于连接状态
+func (this *QReconnectClient) Connected() bool {
+	return this.conn() != nil
+}
+
+func (this *QReconnectClient) Close() {
+	this.close_once.Do(func() {
+		this.mu.Lock()
+		close(this.exit)
+		c := this.current
+		this.current = nil
+		this.wbuffer = nil
+		this.mu.Unlock()
+		if c != nil {
+			c.Close()
+		}
+		//	Dial之前关闭时没有回调
+		if this.close_callback != nil {
+			this.close_callback(this)
+		}
+	})
+}
+
+func NewReconnectClient(opts ReconnectOptions) *QReconnectClient {
+	opts.normalize()
+	//	exit在创建时建立	Dial之前也可以Close	Close之后的Dial返回rpc.ErrConnectionClosed
+	return &QReconnectClient{opts: opts, exit: make(chan struct{})}
+}
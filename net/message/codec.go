@@ -0,0 +1,127 @@
+package message
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"wwt/util"
+	"wwt/util/tlv"
+)
+
+// 消息体编解码	可以自行实现以接入protobuf/msgpack等格式
+type Codec interface {
+	Name() string
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(b []byte, v interface{}) error
+}
+
+type jsonCodec struct{}
+
+func (this jsonCodec) Name() string {
+	return "json"
+}
+
+func (this jsonCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (this jsonCodec) Unmarshal(b []byte, v interface{}) error {
+	return json.Unmarshal(b, v)
+}
+
+type gobCodec struct{}
+
+func (this gobCodec) Name() string {
+	return "gob"
+}
+
+func (this gobCodec) Marshal(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (this gobCodec) Unmarshal(b []byte, v interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(b)).Decode(v)
+}
+
+func JSONCodec() Codec {
+	return jsonCodec{}
+}
+
+func GobCodec() Codec {
+	return gobCodec{}
+}
+
+// 由qnetgen生成的消息类型实现该接口	按字段顺序读写StreamBuffer
+type StreamMarshaler interface {
+	Marshal(stream util.StreamBuffer)
+	Unmarshal(stream util.StreamBuffer)
+}
+
+type streamCodec struct{}
+
+func (this streamCodec) Name() string {
+	return "stream"
+}
+
+func (this streamCodec) Marshal(v interface{}) ([]byte, error) {
+	m, ok := v.(StreamMarshaler)
+	if !ok {
+		return nil, fmt.Errorf("message: %T does not implement StreamMarshaler", v)
+	}
+	stream := util.NewStreamBuffer()
+	m.Marshal(stream)
+	return stream.Bytes(), nil
+}
+
+func (this streamCodec) Unmarshal(b []byte, v interface{}) (err error) {
+	m, ok := v.(StreamMarshaler)
+	if !ok {
+		return fmt.Errorf("message: %T does not implement StreamMarshaler", v)
+	}
+	//	StreamBuffer读取越界时会panic
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("message: unmarshal %T: %v", v, r)
+		}
+	}()
+	stream := util.NewStreamBuffer()
+	stream.Append(b)
+	m.Unmarshal(stream)
+	return nil
+}
+
+func StreamCodec() Codec {
+	return streamCodec{}
+}
+
+type tlvCodec struct{}
+
+func (this tlvCodec) Name() string {
+	return "tlv"
+}
+
+func (this tlvCodec) Marshal(v interface{}) ([]byte, error) {
+	m, ok := v.(tlv.Marshaler)
+	if !ok {
+		return nil, fmt.Errorf("message: %T does not implement tlv.Marshaler", v)
+	}
+	return tlv.Marshal(m), nil
+}
+
+func (this tlvCodec) Unmarshal(b []byte, v interface{}) error {
+	m, ok := v.(tlv.Unmarshaler)
+	if !ok {
+		return fmt.Errorf("message: %T does not implement tlv.Unmarshaler", v)
+	}
+	return tlv.Unmarshal(b, m)
+}
+
+// 使用util/tlv编码	字段可以增减而不破坏旧版本
+func TLVCodec() Codec {
+	return tlvCodec{}
+}
@@ -0,0 +1,153 @@
+package message
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"wwt/util"
+)
+
+// 消息格式
+// | id(4) | body |
+const (
+	ID_SIZE = 4
+)
+
+var ErrUnknownMessage = errors.New("message: unknown message id")
+var ErrNotRegistered = errors.New("message: type not registered")
+var ErrShortMessage = errors.New("message: message too short")
+
+// 可以写出数据帧的对象	TokenHandler与ClientHandler均满足
+type Writer interface {
+	Write([]byte)
+}
+
+type entry struct {
+	id    int
+	typ   reflect.Type //	结构体类型	解码时创建 *typ
+	codec Codec
+}
+
+// 消息类型注册表	类型与ID一一对应
+type Registry struct {
+	mu     sync.RWMutex
+	byID   map[int]*entry
+	byType map[reflect.Type]*entry
+}
+
+func elemType(msg interface{}) reflect.Type {
+	t := reflect.TypeOf(msg)
+	if t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t
+}
+
+// 注册消息类型	msg可以是值或指针	如 (*LoginReq)(nil)
+func (this *Registry) Register(id int, msg interface{}, codec Codec) error {
+	t := elemType(msg)
+	if t == nil {
+		return errors.New("message: register nil type")
+	}
+	if codec == nil {
+		codec = JSONCodec()
+	}
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	if e, ok := this.byID[id]; ok {
+		return fmt.Errorf("message: id %d already registered by %s", id, e.typ)
+	}
+	if e, ok := this.byType[t]; ok {
+		return fmt.Errorf("message: %s already registered with id %d", t, e.id)
+	}
+	e := &entry{id, t, codec}
+	this.byID[id] = e
+	this.byType[t] = e
+	return nil
+}
+
+func (this *Registry) lookupType(t reflect.Type) (*entry, bool) {
+	this.mu.RLock()
+	defer this.mu.RUnlock()
+	e, ok := this.byType[t]
+	return e, ok
+}
+
+func (this *Registry) lookupID(id int) (*entry, bool) {
+	this.mu.RLock()
+	defer this.mu.RUnlock()
+	e, ok := this.byID[id]
+	return e, ok
+}
+
+// 查询消息类型的ID
+func (this *Registry) ID(msg interface{}) (int, bool) {
+	e, ok := this.lookupType(elemType(msg))
+	if !ok {
+		return 0, false
+	}
+	return e.id, true
+}
+
+// 编码消息	结果可以直接作为一帧发送
+func (this *Registry) Encode(msg interface{}) ([]byte, error) {
+	e, ok := this.lookupType(elemType(msg))
+	if !ok {
+		return nil, ErrNotRegistered
+	}
+	body, err := e.codec.Marshal(msg)
+	if err != nil {
+		return nil, err
+	}
+	stream := util.NewStreamBuffer()
+	stream.WriteInt(e.id)
+	stream.Append(body)
+	return stream.Bytes(), nil
+}
+
+// 解码消息	返回消息ID与指向新建结构体的指针
+func (this *Registry) Decode(b []byte) (int, interface{}, error) {
+	if len(b) < ID_SIZE {
+		return 0, nil, ErrShortMessage
+	}
+	stream := util.NewStreamBuffer()
+	stream.Append(b[:ID_SIZE])
+	id := stream.ReadInt()
+	e, ok := this.lookupID(id)
+	if !ok {
+		return id, nil, ErrUnknownMessage
+	}
+	v := reflect.New(e.typ)
+	if err := e.codec.Unmarshal(b[ID_SIZE:], v.Interface()); err != nil {
+		return id, nil, err
+	}
+	return id, v.Interface(), nil
+}
+
+// 编码并发送消息
+func (this *Registry) Send(w Writer, msg interface{}) error {
+	b, err := this.Encode(msg)
+	if err != nil {
+		return err
+	}
+	w.Write(b)
+	return nil
+}
+
+func NewRegistry() *Registry {
+	return &Registry{
+		byID:   make(map[int]*entry),
+		byType: make(map[reflect.Type]*entry),
+	}
+}
+
+var DefaultRegistry = NewRegistry()
+
+func Register(id int, msg interface{}, codec Codec) error {
+	return DefaultRegistry.Register(id, msg, codec)
+}
+
+func Send(w Writer, msg interface{}) error {
+	return DefaultRegistry.Send(w, msg)
+}
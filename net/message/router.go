@@ -0,0 +1,116 @@
+package message
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"reflect"
+	"sync"
+	"wwt/net/client"
+	"wwt/net/server"
+	"wwt/net/server/connection"
+)
+
+var ErrNoHandler = errors.New("message: no handler for message")
+
+// 处理消息出错时的回调
+type ErrorFunc func(session interface{}, err error)
+
+// 按消息类型分发到处理函数
+// 处理函数形如 func(connection.TokenHandler, *LoginReq) 或 func(client.ClientHandler, *LoginAck)
+type Router struct {
+	registry *Registry
+
+	mu       sync.RWMutex
+	handlers map[int]reflect.Value
+	sessions map[int]reflect.Type
+
+	onError ErrorFunc
+}
+
+// 注册处理函数	消息类型需要先在注册表中注册
+func (this *Router) Handle(handler interface{}) error {
+	v := reflect.ValueOf(handler)
+	t := v.Type()
+	if t.Kind() != reflect.Func || t.NumIn() != 2 || t.NumOut() != 0 || t.In(1).Kind() != reflect.Ptr {
+		return fmt.Errorf("message: handler must be func(session, *Msg), got %s", t)
+	}
+	e, ok := this.registry.lookupType(t.In(1).Elem())
+	if !ok {
+		return fmt.Errorf("message: %s: %s", ErrNotRegistered.Error(), t.In(1).Elem())
+	}
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	this.handlers[e.id] = v
+	this.sessions[e.id] = t.In(0)
+	return nil
+}
+
+// 与Handle相同	出错时panic	用于初始化阶段
+func (this *Router) MustHandle(handler interface{}) {
+	if err := this.Handle(handler); err != nil {
+		panic(err)
+	}
+}
+
+func (this *Router) SetErrorHandler(f ErrorFunc) {
+	this.onError = f
+}
+
+// 解码并调用对应的处理函数
+func (this *Router) Dispatch(session interface{}, b []byte) error {
+	id, msg, err := this.registry.Decode(b)
+	if err != nil {
+		return err
+	}
+	this.mu.RLock()
+	h, ok := this.handlers[id]
+	st := this.sessions[id]
+	this.mu.RUnlock()
+	if !ok {
+		return ErrNoHandler
+	}
+	sv := reflect.ValueOf(session)
+	if !sv.IsValid() || !sv.Type().AssignableTo(st) {
+		return fmt.Errorf("message: session %T is not assignable to %s", session, st)
+	}
+	h.Call([]reflect.Value{sv, reflect.ValueOf(msg)})
+	return nil
+}
+
+func (this *Router) fail(session interface{}, err error) {
+	if this.onError != nil {
+		this.onError(session, err)
+		return
+	}
+	log.Printf("Router %p: %s.\n", this, err.Error())
+}
+
+// 用作QServer的处理函数
+func (this *Router) Processer() server.ProcesseFunc {
+	return func(token connection.TokenHandler, n int, b []byte) {
+		if err := this.Dispatch(token, b); err != nil {
+			this.fail(token, err)
+		}
+	}
+}
+
+// 用作QClient的读回调
+func (this *Router) ReadCallback() client.ReadCallback {
+	return func(c client.ClientHandler, n int, b []byte) {
+		if err := this.Dispatch(c, b); err != nil {
+			this.fail(c, err)
+		}
+	}
+}
+
+func NewRouter(registry *Registry) *Router {
+	if registry == nil {
+		registry = DefaultRegistry
+	}
+	return &Router{
+		registry: registry,
+		handlers: make(map[int]reflect.Value),
+		sessions: make(map[int]reflect.Type),
+	}
+}
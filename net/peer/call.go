@@ -0,0 +1,76 @@
+package peer
+
+import (
+	"sync"
+)
+
+type callResult struct {
+	data []byte
+	err  error
+}
+
+// 等待应答的请求
+type pendingCalls struct {
+	mu     sync.Mutex
+	seq    uint32
+	calls  map[uint32]chan callResult
+	closed bool
+}
+
+func (this *pendingCalls) init() {
+	this.mu.Lock()
+	this.seq = 0
+	this.calls = make(map[uint32]chan callResult)
+	this.closed = false
+	this.mu.Unlock()
+}
+
+func (this *pendingCalls) add() (uint32, chan callResult, error) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	if this.closed || this.calls == nil {
+		return 0, nil, ErrConnectionClosed
+	}
+	this.seq++
+	ch := make(chan callResult, 1)
+	this.calls[this.seq] = ch
+	return this.seq, ch, nil
+}
+
+func (this *pendingCalls) remove(id uint32) {
+	this.mu.Lock()
+	delete(this.calls, id)
+	this.mu.Unlock()
+}
+
+// 将应答交给对应的请求	没有对应请求时返回false
+func (this *pendingCalls) deliver(b []byte) bool {
+	f, ok := DecodeRPC(b)
+	if !ok || f.Kind == KIND_REQUEST {
+		return false
+	}
+	this.mu.Lock()
+	ch, ok := this.calls[f.ID]
+	delete(this.calls, f.ID)
+	this.mu.Unlock()
+	if !ok {
+		return false
+	}
+	if f.Kind == KIND_ERROR {
+		ch <- callResult{nil, &RemoteError{Message: string(f.Body)}}
+	} else {
+		ch <- callResult{f.Body, nil}
+	}
+	return true
+}
+
+// 连接关闭	所有未完成的请求返回错误
+func (this *pendingCalls) failAll() {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	this.closed = true
+	for id, ch := range this.calls {
+		ch <- callResult{nil, ErrConnectionClosed}
+		delete(this.calls, id)
+	}
+}
@@ -0,0 +1,162 @@
+package peer
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"wwt/util"
+)
+
+const (
+	MAX_FRAME_SIZE  = 16 << 20  //	单帧最大字节数
+	MAX_UINT16_SIZE = 1<<16 - 1 //	2字节长度前缀能表示的最大帧
+)
+
+var ErrFrameTooLarge = errors.New("peer: frame too large")
+var ErrInvalidFrame = errors.New("peer: invalid frame")
+
+// 帧编解码
+// Encode 为一帧数据加上帧头	空数据表示心跳帧	数据无法用该格式表示时返回错误
+// Decode 从缓冲区头部解析一帧	consumed 为0表示数据不完整需要继续读取
+// 解析出长度为0的帧视为心跳帧
+type FrameCodec interface {
+	Encode(payload []byte) ([]byte, error)
+	Decode(buf []byte) (frame []byte, consumed int, err error)
+}
+
+// 4字节大端有符号长度前缀	默认格式
+type int32BECodec struct{}
+
+func (this int32BECodec) Encode(payload []byte) ([]byte, error) {
+	if len(payload) > MAX_FRAME_SIZE {
+		return nil, ErrFrameTooLarge
+	}
+	stream := util.NewStreamBuffer()
+	stream.WriteInt(len(payload))
+	stream.Append(payload)
+	return stream.Bytes(), nil
+}
+
+func (this int32BECodec) Decode(buf []byte) ([]byte, int, error) {
+	if len(buf) < 4 {
+		return nil, 0, nil
+	}
+	length := int(int32(binary.BigEndian.Uint32(buf)))
+	if length < 0 {
+		return nil, 0, ErrInvalidFrame
+	}
+	if length > MAX_FRAME_SIZE {
+		return nil, 0, ErrFrameTooLarge
+	}
+	if len(buf) < 4+length {
+		return nil, 0, nil
+	}
+	return buf[4 : 4+length], 4 + length, nil
+}
+
+// 2字节小端无符号长度前缀
+type uint16LECodec struct{}
+
+func (this uint16LECodec) Encode(payload []byte) ([]byte, error) {
+	if len(payload) > MAX_UINT16_SIZE {
+		return nil, ErrFrameTooLarge
+	}
+	b := make([]byte, 2+len(payload))
+	binary.LittleEndian.PutUint16(b, uint16(len(payload)))
+	copy(b[2:], payload)
+	return b, nil
+}
+
+func (this uint16LECodec) Decode(buf []byte) ([]byte, int, error) {
+	if len(buf) < 2 {
+		return nil, 0, nil
+	}
+	length := int(binary.LittleEndian.Uint16(buf))
+	if len(buf) < 2+length {
+		return nil, 0, nil
+	}
+	return buf[2 : 2+length], 2 + length, nil
+}
+
+// varint(无符号)长度前缀
+type varintCodec struct{}
+
+func (this varintCodec) Encode(payload []byte) ([]byte, error) {
+	if len(payload) > MAX_FRAME_SIZE {
+		return nil, ErrFrameTooLarge
+	}
+	b := make([]byte, binary.MaxVarintLen64+len(payload))
+	n := binary.PutUvarint(b, uint64(len(payload)))
+	n += copy(b[n:], payload)
+	return b[:n], nil
+}
+
+func (this varintCodec) Decode(buf []byte) ([]byte, int, error) {
+	length, n := binary.Uvarint(buf)
+	if n == 0 {
+		return nil, 0, nil
+	}
+	if n < 0 {
+		return nil, 0, ErrInvalidFrame
+	}
+	if length > MAX_FRAME_SIZE {
+		return nil, 0, ErrFrameTooLarge
+	}
+	end := n + int(length)
+	if len(buf) < end {
+		return nil, 0, nil
+	}
+	return buf[n:end], end, nil
+}
+
+// 以换行符分隔的文本帧	兼容 \r\n	payload 中包含换行符或以\r结尾时无法编码
+type lineCodec struct{}
+
+func (this lineCodec) Encode(payload []byte) ([]byte, error) {
+	if len(payload) > MAX_FRAME_SIZE {
+		return nil, ErrFrameTooLarge
+	}
+	if bytes.IndexByte(payload, '\n') >= 0 || (len(payload) > 0 && payload[len(payload)-1] == '\r') {
+		return nil, ErrInvalidFrame
+	}
+	b := make([]byte, len(payload)+1)
+	copy(b, payload)
+	b[len(payload)] = '\n'
+	return b, nil
+}
+
+func (this lineCodec) Decode(buf []byte) ([]byte, int, error) {
+	i := bytes.IndexByte(buf, '\n')
+	if i < 0 {
+		if len(buf) > MAX_FRAME_SIZE {
+			return nil, 0, ErrFrameTooLarge
+		}
+		return nil, 0, nil
+	}
+	frame := buf[:i]
+	if len(frame) > 0 && frame[len(frame)-1] == '\r' {
+		frame = frame[:len(frame)-1]
+	}
+	return frame, i + 1, nil
+}
+
+// 默认帧格式: 4字节大端有符号长度前缀
+func DefaultFrameCodec() FrameCodec {
+	return int32BECodec{}
+}
+
+func NewInt32BECodec() FrameCodec {
+	return int32BECodec{}
+}
+
+func NewUint16LECodec() FrameCodec {
+	return uint16LECodec{}
+}
+
+func NewVarintCodec() FrameCodec {
+	return varintCodec{}
+}
+
+func NewLineCodec() FrameCodec {
+	return lineCodec{}
+}
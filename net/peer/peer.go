@@ -0,0 +1,292 @@
+package peer
+
+import (
+	"context"
+	"log"
+	"net"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+	"time"
+	"wwt/ctrl"
+	"wwt/util"
+)
+
+const (
+	BUFFER_SIZE = 2048
+	RCHAN_SIZE  = 1024
+	WCHAN_SIZE  = 1024
+
+	CALL_TIMEOUT = 30 * time.Second //	ctx没有截止时间时Call的默认超时
+)
+
+type ReadFunc func(int, []byte)
+type CloseFunc func()
+
+type RChan chan []byte
+type WChan chan []byte
+
+// 服务端Token与客户端共用的分帧连接
+// 负责读取、分帧、发送三个goroutine以及心跳与请求/应答的处理
+// 两端的行为完全一致	双方都可以作为对等端发起Call
+type QPeer struct {
+	conn    net.Conn
+	codec   FrameCodec
+	onRead  ReadFunc
+	onClose CloseFunc
+
+	r_exit   chan struct{}
+	r_stream util.StreamBuffer
+	r_chan   RChan
+
+	w_exit chan struct{}
+	w_chan WChan
+
+	task_group sync.WaitGroup
+	close_once sync.Once
+
+	closed int32 //	由Close设置	其他goroutine通过IsClosed读取
+
+	rpc_enabled bool //	是否识别请求/应答帧
+	pending     pendingCalls
+
+	tap FrameTap
+}
+
+// 连接关闭后写入的数据直接丢弃	阻塞在发送管道上的调用随w_exit返回
+func (this *QPeer) Write(b []byte) {
+	defer func() {
+		if r := recover(); r != nil {
+			ctrl.ReportPanic("QPeer.Write "+this.RemoteAddr().String(), r, debug.Stack())
+		}
+	}()
+	select {
+	case <-this.w_exit:
+	case this.w_chan <- b:
+	}
+}
+
+func (this *QPeer) sendAsync() {
+	defer func() {
+		this.task_group.Done()
+		if r := recover(); r != nil {
+			ctrl.ReportPanic("QPeer.sendAsync "+this.RemoteAddr().String(), r, debug.Stack())
+		}
+		this.Close()
+	}()
+
+	for {
+		select {
+		case <-this.w_exit:
+			return
+		case b := <-this.w_chan:
+			if b == nil {
+				return
+			}
+			frame, err := this.codec.Encode(b)
+			if err != nil {
+				//	无法编码的数据直接丢弃	不写入半帧	连接保持可用
+				log.Printf("QPeer %p: Drop frame to host: %s. %s.\n", this, this.RemoteAddr(), err.Error())
+				continue
+			}
+			n, err := this.conn.Write(frame)
+			if n <= 0 || err != nil {
+				return
+			}
+			//	写入成功后才记录	丢弃或未发出的帧不出现在录制中
+			if this.tap != nil && len(b) > 0 {
+				this.tap.Frame(true, b)
+			}
+		}
+	}
+}
+
+func (this *QPeer) StartSend() {
+	ctrl.StartGoroutines(func() {
+		this.sendAsync()
+	})
+}
+
+func (this *QPeer) readAsync() {
+	defer func() {
+		this.task_group.Done()
+		if r := recover(); r != nil {
+			ctrl.ReportPanic("QPeer.readAsync "+this.RemoteAddr().String(), r, debug.Stack())
+		}
+		this.Close()
+	}()
+
+	for {
+		select {
+		case <-this.r_exit:
+			return
+		default:
+			b := make([]byte, BUFFER_SIZE)
+			n, err := this.conn.Read(b) //	可引发连接异常
+			if n <= 0 || err != nil {
+				return
+			}
+			//	processRead已经退出时管道可能是满的	随r_exit返回	不阻塞Close
+			select {
+			case this.r_chan <- b[:n]:
+			case <-this.r_exit:
+				return
+			}
+		}
+	}
+}
+
+func (this *QPeer) processRead() {
+	defer func() {
+		this.task_group.Done()
+		//	上层处理函数的panic	上报后关闭连接
+		if r := recover(); r != nil {
+			ctrl.ReportPanic("QPeer.processRead "+this.RemoteAddr().String(), r, debug.Stack())
+			ctrl.StartGoroutines(func() {
+				this.Close()
+			})
+		}
+	}()
+	for {
+		select {
+		case <-this.r_exit:
+			return
+		case b := <-this.r_chan:
+			if b == nil {
+				return
+			}
+			this.r_stream.Append(b)
+			for !this.r_stream.Empty() {
+				frame, consumed, err := this.codec.Decode(this.r_stream.Bytes())
+				if err != nil {
+					//	非法数据帧	关闭连接
+					log.Printf("QPeer %p: %s from host: %s.\n", this, err.Error(), this.RemoteAddr())
+					ctrl.StartGoroutines(func() {
+						this.Close()
+					})
+					return
+				}
+				if consumed == 0 {
+					//	数据包不完整
+					break
+				}
+				length := len(frame)
+				var data []byte
+				if length > 0 {
+					data = make([]byte, length)
+					copy(data, frame)
+				}
+				this.r_stream.ReadNBytes(consumed)
+				if length == 0 {
+					//	心跳包
+					log.Printf("Heart beat from host: %s.\n", this.RemoteAddr())
+					continue
+				}
+				if this.tap != nil {
+					this.tap.Frame(false, data)
+				}
+				if !this.rpc_enabled || !this.pending.deliver(data) {
+					this.onRead(length, data)
+				}
+			}
+		}
+	}
+}
+
+func (this *QPeer) StartRead() {
+	ctrl.StartGoroutines(func() {
+		this.readAsync()
+	})
+	ctrl.StartGoroutines(func() {
+		this.processRead()
+	})
+}
+
+// 开启请求/应答帧	需在StartRead之前调用
+// 未开启时所有数据帧原样交给onRead	以'Q','R'开头的普通数据不会被误认为应答
+func (this *QPeer) EnableRPC() {
+	this.rpc_enabled = true
+}
+
+// 发送请求并等待对端对应的应答	需先调用EnableRPC
+func (this *QPeer) Call(ctx context.Context, payload []byte) ([]byte, error) {
+	if !this.rpc_enabled {
+		return nil, ErrRPCDisabled
+	}
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, CALL_TIMEOUT)
+		defer cancel()
+	}
+	id, ch, err := this.pending.add()
+	if err != nil {
+		return nil, err
+	}
+	this.Write(EncodeRPC(KIND_REQUEST, id, payload))
+	select {
+	case res := <-ch:
+		return res.data, res.err
+	case <-ctx.Done():
+		this.pending.remove(id)
+		if ctx.Err() == context.DeadlineExceeded {
+			return nil, ErrCallTimeout
+		}
+		return nil, ctx.Err()
+	}
+}
+
+func (this *QPeer) RemoteAddr() net.Addr {
+	return this.conn.RemoteAddr()
+}
+
+func (this *QPeer) LocalAddr() net.Addr {
+	return this.conn.LocalAddr()
+}
+
+func (this *QPeer) IsClosed() bool {
+	return atomic.LoadInt32(&this.closed) == 1
+}
+
+func (this *QPeer) Close() {
+	this.close_once.Do(func() {
+		close(this.r_exit) //	关闭对远端数据流的处理		影响到processRead方法		放弃从管道中读入数据并退出
+		close(this.w_exit) //	对上层应用关闭输入口	影响到Write方法	针对准备写入数据时被阻塞的goroutine
+		this.conn.Close()  //	关闭连接，readAsync,sendAsync会触发异常并退出
+
+		this.task_group.Wait() //	等待该连接所有任务	goroutuines	退出
+
+		close(this.r_chan) //	关闭处理数据流管道
+		//	发送管道不关闭	Close之后的Write在w_exit上返回	不会向已关闭的管道写入而panic
+		this.pending.failAll()
+		atomic.StoreInt32(&this.closed, 1)
+		if this.tap != nil {
+			this.tap.Close()
+		}
+		this.onClose()
+	})
+}
+
+func NewQPeer(conn net.Conn, onRead ReadFunc, onClose CloseFunc) *QPeer {
+	return NewQPeerWithCodec(conn, DefaultFrameCodec(), onRead, onClose)
+}
+
+// 使用指定的帧格式	codec为nil时使用默认格式
+func NewQPeerWithCodec(conn net.Conn, codec FrameCodec, onRead ReadFunc, onClose CloseFunc) *QPeer {
+	if codec == nil {
+		codec = DefaultFrameCodec()
+	}
+	peer := &QPeer{
+		conn:     conn,
+		codec:    codec,
+		onRead:   onRead,
+		onClose:  onClose,
+		r_exit:   make(chan struct{}),
+		r_stream: util.NewStreamBuffer(),
+		r_chan:   make(RChan, RCHAN_SIZE),
+		w_exit:   make(chan struct{}),
+		w_chan:   make(WChan, WCHAN_SIZE),
+	}
+	peer.pending.init()
+	peer.task_group.Add(3)
+	return peer
+}
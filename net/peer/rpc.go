@@ -0,0 +1,93 @@
+package peer
+
+import (
+	"errors"
+	"wwt/util"
+)
+
+// 请求/应答帧格式	由QPeer直接使用	net/rpc 对外提供同样的接口
+// | 'Q' | 'R' | kind(1) | id(4) | body |
+// 帧头以魔数开头	只有显式开启RPC的连接才识别该格式
+// 同一连接上混用请求与普通数据帧时普通数据不应以该魔数开头
+const (
+	RPC_MAGIC0 byte = 'Q'
+	RPC_MAGIC1 byte = 'R'
+
+	KIND_REQUEST  byte = 1
+	KIND_RESPONSE byte = 2
+	KIND_ERROR    byte = 3
+
+	RPC_HEADER_SIZE = 7
+)
+
+var ErrConnectionClosed = errors.New("rpc: connection closed")
+var ErrCallTimeout = errors.New("rpc: call timeout")
+var ErrRPCDisabled = errors.New("rpc: request/response framing not enabled")
+
+// 远端返回的错误
+type RemoteError struct {
+	Message string
+}
+
+func (this *RemoteError) Error() string {
+	return "rpc: remote error: " + this.Message
+}
+
+type RPCFrame struct {
+	Kind byte
+	ID   uint32
+	Body []byte
+}
+
+type RPCRequest struct {
+	ID   uint32
+	Body []byte
+}
+
+// 可以写出数据帧的对象	TokenHandler与ClientHandler均满足
+type RPCWriter interface {
+	Write([]byte)
+}
+
+func EncodeRPC(kind byte, id uint32, body []byte) []byte {
+	stream := util.NewStreamBuffer()
+	stream.WriteByte(RPC_MAGIC0)
+	stream.WriteByte(RPC_MAGIC1)
+	stream.WriteByte(kind)
+	stream.WriteInt(int(int32(id)))
+	stream.Append(body)
+	return stream.Bytes()
+}
+
+func DecodeRPC(b []byte) (*RPCFrame, bool) {
+	if len(b) < RPC_HEADER_SIZE || b[0] != RPC_MAGIC0 || b[1] != RPC_MAGIC1 {
+		return nil, false
+	}
+	kind := b[2]
+	if kind != KIND_REQUEST && kind != KIND_RESPONSE && kind != KIND_ERROR {
+		return nil, false
+	}
+	stream := util.NewStreamBuffer()
+	stream.Append(b[3:])
+	id := uint32(stream.ReadInt())
+	return &RPCFrame{kind, id, b[RPC_HEADER_SIZE:]}, true
+}
+
+// 解析请求帧	不是请求帧时返回false
+func ParseRPCRequest(b []byte) (*RPCRequest, bool) {
+	f, ok := DecodeRPC(b)
+	if !ok || f.Kind != KIND_REQUEST {
+		return nil, false
+	}
+	return &RPCRequest{f.ID, f.Body}, true
+}
+
+// 回复指定请求
+func ReplyRPC(w RPCWriter, req *RPCRequest, payload []byte) {
+	w.Write(EncodeRPC(KIND_RESPONSE, req.ID, payload))
+}
+
+// 以错误回复指定请求
+func ReplyRPCError(w RPCWriter, req *RPCRequest, msg string) {
+	w.Write(EncodeRPC(KIND_ERROR, req.ID, []byte(msg)))
+}
@@ -0,0 +1,20 @@
+package peer
+
+import "net"
+
+// 旁路观察连接上的每一帧	用于流量录制
+// Frame 在读到一帧或一帧成功写入连接后调用	心跳包不经过Frame	Close 在连接关闭时调用一次
+type FrameTap interface {
+	Frame(out bool, frame []byte)
+	Close()
+}
+
+// 为新连接创建FrameTap	返回nil表示不观察该连接
+type TapFactory interface {
+	Open(conn net.Conn) FrameTap
+}
+
+// 需在StartRead之前调用
+func (this *QPeer) SetFrameTap(tap FrameTap) {
+	this.tap = tap
+}
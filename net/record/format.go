@@ -0,0 +1,176 @@
+package record
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"time"
+)
+
+// 录制文件格式
+//
+//	文件头	"QREC" + 版本(1字节) + 开始时间(8字节	Unix纳秒	大端)
+//	事件	类型(1字节) + 连接ID(uvarint) + 距开始的微秒数(uvarint) + 内容
+//		EVENT_OPEN	远端地址与本地地址	各为 uvarint长度 + 字节
+//		EVENT_IN/EVENT_OUT	帧内容	uvarint长度 + 字节
+//		EVENT_CLOSE	无内容
+const (
+	FILE_MAGIC   = "QREC"
+	FILE_VERSION = 1
+	HEADER_SIZE  = 13
+)
+
+type EventType byte
+
+const (
+	EVENT_OPEN  EventType = 1 //	新连接
+	EVENT_IN    EventType = 2 //	收到的帧
+	EVENT_OUT   EventType = 3 //	发出的帧
+	EVENT_CLOSE EventType = 4 //	连接关闭
+)
+
+func (this EventType) String() string {
+	switch this {
+	case EVENT_OPEN:
+		return "open"
+	case EVENT_IN:
+		return "in"
+	case EVENT_OUT:
+		return "out"
+	case EVENT_CLOSE:
+		return "close"
+	}
+	return "unknown"
+}
+
+var ErrBadMagic = errors.New("record: not a recording file")
+var ErrBadVersion = errors.New("record: unsupported recording version")
+var ErrBadEvent = errors.New("record: corrupted event")
+
+const MAX_EVENT_SIZE = 64 * 1024 * 1024
+
+type Event struct {
+	Type   EventType
+	Conn   uint64
+	Offset time.Duration //	距录制开始的时间
+
+	Remote string //	EVENT_OPEN
+	Local  string //	EVENT_OPEN
+	Frame  []byte //	EVENT_IN/EVENT_OUT
+}
+
+func writeHeader(w io.Writer, start time.Time) error {
+	buf := make([]byte, HEADER_SIZE)
+	copy(buf, FILE_MAGIC)
+	buf[4] = FILE_VERSION
+	binary.BigEndian.PutUint64(buf[5:], uint64(start.UnixNano()))
+	_, err := w.Write(buf)
+	return err
+}
+
+func appendBytes(buf []byte, b []byte) []byte {
+	buf = binary.AppendUvarint(buf, uint64(len(b)))
+	return append(buf, b...)
+}
+
+func encodeEvent(buf []byte, e *Event) []byte {
+	buf = append(buf, byte(e.Type))
+	buf = binary.AppendUvarint(buf, e.Conn)
+	buf = binary.AppendUvarint(buf, uint64(e.Offset/time.Microsecond))
+	switch e.Type {
+	case EVENT_OPEN:
+		buf = appendBytes(buf, []byte(e.Remote))
+		buf = appendBytes(buf, []byte(e.Local))
+	case EVENT_IN, EVENT_OUT:
+		buf = appendBytes(buf, e.Frame)
+	}
+	return buf
+}
+
+// 顺序读取录制文件
+type Reader struct {
+	r     *bufio.Reader
+	start time.Time
+}
+
+// 读取并校验文件头
+func NewReader(r io.Reader) (*Reader, error) {
+	br := bufio.NewReader(r)
+	head := make([]byte, HEADER_SIZE)
+	if _, err := io.ReadFull(br, head); err != nil {
+		return nil, err
+	}
+	if string(head[:4]) != FILE_MAGIC {
+		return nil, ErrBadMagic
+	}
+	if head[4] != FILE_VERSION {
+		return nil, ErrBadVersion
+	}
+	start := time.Unix(0, int64(binary.BigEndian.Uint64(head[5:])))
+	return &Reader{r: br, start: start}, nil
+}
+
+// 录制开始的时间
+func (this *Reader) Start() time.Time {
+	return this.start
+}
+
+func (this *Reader) readBytes() ([]byte, error) {
+	n, err := binary.ReadUvarint(this.r)
+	if err != nil {
+		return nil, err
+	}
+	if n > MAX_EVENT_SIZE {
+		return nil, ErrBadEvent
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(this.r, b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// 读取下一个事件	文件结束时返回io.EOF	事件被截断时返回io.ErrUnexpectedEOF
+func (this *Reader) Next() (*Event, error) {
+	t, err := this.r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	e := &Event{Type: EventType(t)}
+	if e.Conn, err = binary.ReadUvarint(this.r); err != nil {
+		return nil, unexpected(err)
+	}
+	us, err := binary.ReadUvarint(this.r)
+	if err != nil {
+		return nil, unexpected(err)
+	}
+	e.Offset = time.Duration(us) * time.Microsecond
+	switch e.Type {
+	case EVENT_OPEN:
+		remote, err := this.readBytes()
+		if err != nil {
+			return nil, unexpected(err)
+		}
+		local, err := this.readBytes()
+		if err != nil {
+			return nil, unexpected(err)
+		}
+		e.Remote, e.Local = string(remote), string(local)
+	case EVENT_IN, EVENT_OUT:
+		if e.Frame, err = this.readBytes(); err != nil {
+			return nil, unexpected(err)
+		}
+	case EVENT_CLOSE:
+	default:
+		return nil, ErrBadEvent
+	}
+	return e, nil
+}
+
+func unexpected(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
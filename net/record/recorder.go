@@ -0,0 +1,169 @@
+package record
+
+import (
+	"bufio"
+	"io"
+	"log"
+	"net"
+	"os"
+	"sync"
+	"time"
+	"wwt/ctrl"
+	"wwt/net/peer"
+)
+
+const (
+	FLUSH_INTERVAL = time.Second
+	WRITER_SIZE    = 64 * 1024
+)
+
+// 把连接上的帧写入录制文件	可以同时录制多个连接
+// 实现peer.TapFactory	通过QServer.SetRecorder或QClient.SetRecorder启用
+type Recorder struct {
+	mu     sync.Mutex
+	w      *bufio.Writer
+	closer io.Closer
+	start  time.Time
+	next   uint64
+	buf    []byte
+	err    error
+	closed bool
+
+	exit chan struct{}
+}
+
+func (this *Recorder) write(e *Event) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	if this.closed || this.err != nil {
+		return
+	}
+	e.Offset = time.Since(this.start)
+	this.buf = encodeEvent(this.buf[:0], e)
+	if _, err := this.w.Write(this.buf); err != nil {
+		//	写入失败后停止录制	不影响连接本身
+		this.err = err
+		log.Printf("Recorder %p: Stop recording. %s.\n", this, err.Error())
+	}
+}
+
+func (this *Recorder) Open(conn net.Conn) peer.FrameTap {
+	this.mu.Lock()
+	if this.closed {
+		this.mu.Unlock()
+		return nil
+	}
+	this.next++
+	id := this.next
+	this.mu.Unlock()
+
+	remote, local := "", ""
+	if a := conn.RemoteAddr(); a != nil {
+		remote = a.String()
+	}
+	if a := conn.LocalAddr(); a != nil {
+		local = a.String()
+	}
+	this.write(&Event{Type: EVENT_OPEN, Conn: id, Remote: remote, Local: local})
+	return &connTap{this, id}
+}
+
+func (this *Recorder) Flush() error {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	if this.err != nil {
+		return this.err
+	}
+	return this.w.Flush()
+}
+
+// 第一次写入失败的错误
+func (this *Recorder) Err() error {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	return this.err
+}
+
+// 结束录制	之后的帧被忽略
+func (this *Recorder) Close() error {
+	this.mu.Lock()
+	if this.closed {
+		this.mu.Unlock()
+		return nil
+	}
+	this.closed = true
+	close(this.exit)
+	err := this.err
+	if err == nil {
+		err = this.w.Flush()
+	}
+	this.mu.Unlock()
+	if this.closer != nil {
+		if cerr := this.closer.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+func (this *Recorder) flushLoop() {
+	ticker := time.NewTicker(FLUSH_INTERVAL)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-this.exit:
+			return
+		case <-ticker.C:
+			this.Flush()
+		}
+	}
+}
+
+// 录制到w	w实现io.Closer时由Recorder.Close关闭
+func NewRecorder(w io.Writer) (*Recorder, error) {
+	start := time.Now()
+	bw := bufio.NewWriterSize(w, WRITER_SIZE)
+	if err := writeHeader(bw, start); err != nil {
+		return nil, err
+	}
+	rec := &Recorder{w: bw, start: start, exit: make(chan struct{})}
+	if c, ok := w.(io.Closer); ok {
+		rec.closer = c
+	}
+	ctrl.StartGoroutines(func() {
+		rec.flushLoop()
+	})
+	return rec, nil
+}
+
+// 创建录制文件
+func Create(path string) (*Recorder, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	rec, err := NewRecorder(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return rec, nil
+}
+
+// 单个连接的录制
+type connTap struct {
+	rec *Recorder
+	id  uint64
+}
+
+func (this *connTap) Frame(out bool, frame []byte) {
+	t := EVENT_IN
+	if out {
+		t = EVENT_OUT
+	}
+	this.rec.write(&Event{Type: t, Conn: this.id, Frame: frame})
+}
+
+func (this *connTap) Close() {
+	this.rec.write(&Event{Type: EVENT_CLOSE, Conn: this.id})
+}
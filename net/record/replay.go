@@ -0,0 +1,216 @@
+package record
+
+import (
+	"context"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"time"
+	"wwt/net/client"
+	"wwt/net/peer"
+)
+
+const (
+	DEFAULT_LINGER = time.Second
+)
+
+// 回放时服务器返回的帧	conn为录制文件中的连接ID
+type ReplayFrameFunc func(conn uint64, frame []byte)
+
+// 回放配置
+type ReplayOptions struct {
+	Speed    float64  //	1为原速	2为两倍速	<=0 表示不等待	尽快发送
+	Outbound bool     //	回放OUT方向的帧	用于客户端录制的文件	默认回放服务器录制的IN方向
+	Conns    []uint64 //	只回放这些连接	为空时回放全部
+
+	Dial    client.DialOptions
+	Codec   peer.FrameCodec
+	OnFrame ReplayFrameFunc
+
+	Linger time.Duration //	发送完最后一帧后等待应答的时间
+}
+
+type ReplayStats struct {
+	Conns     int //	建立的连接数
+	DialFail  int //	拨号失败的连接数
+	Frames    int //	发送的帧数
+	Responses int //	收到的帧数
+	Duration  time.Duration
+}
+
+type replayer struct {
+	address string
+	opts    ReplayOptions
+	only    map[uint64]bool
+
+	mu      sync.Mutex
+	clients map[uint64]*client.QClient
+	stats   ReplayStats
+}
+
+func (this *replayer) open(ctx context.Context, id uint64) {
+	c := &client.QClient{}
+	c.SetDialOptions(this.opts.Dial)
+	c.SetFrameCodec(this.opts.Codec)
+	err := c.DialContext(ctx, this.address, func(_ client.ClientHandler, _ int, b []byte) {
+		this.mu.Lock()
+		this.stats.Responses++
+		this.mu.Unlock()
+		if this.opts.OnFrame != nil {
+			this.opts.OnFrame(id, b)
+		}
+	}, func(client.ClientHandler) {})
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	if err != nil {
+		this.stats.DialFail++
+		log.Printf("Replay: connection %d dial %s fail. %s.\n", id, this.address, err.Error())
+		return
+	}
+	this.stats.Conns++
+	this.clients[id] = c
+}
+
+func (this *replayer) send(id uint64, frame []byte) {
+	this.mu.Lock()
+	c, ok := this.clients[id]
+	if ok {
+		this.stats.Frames++
+	}
+	this.mu.Unlock()
+	if ok {
+		c.Write(frame)
+	}
+}
+
+func (this *replayer) close(id uint64) {
+	this.mu.Lock()
+	c, ok := this.clients[id]
+	delete(this.clients, id)
+	this.mu.Unlock()
+	if ok {
+		c.Close()
+	}
+}
+
+func (this *replayer) closeAll() {
+	this.mu.Lock()
+	clients := this.clients
+	this.clients = make(map[uint64]*client.QClient)
+	this.mu.Unlock()
+	for _, c := range clients {
+		c.Close()
+	}
+}
+
+// 按录制时的节奏等待到offset	offset从第一个回放的事件起算	ctx结束时返回错误
+func (this *replayer) wait(ctx context.Context, start time.Time, offset time.Duration) error {
+	if this.opts.Speed <= 0 {
+		return ctx.Err()
+	}
+	d := time.Until(start.Add(time.Duration(float64(offset) / this.opts.Speed)))
+	if d <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+func (this *replayer) run(ctx context.Context, r *Reader) error {
+	start := time.Now()
+	//	事件的Offset从录制开始起算	以第一个回放的事件为起点	不等待录制开头或被过滤掉的空闲时间
+	var first time.Duration
+	started := false
+	defer func() {
+		this.stats.Duration = time.Since(start)
+	}()
+	send := EVENT_IN
+	if this.opts.Outbound {
+		send = EVENT_OUT
+	}
+	for {
+		e, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			this.closeAll()
+			return err
+		}
+		if len(this.only) > 0 && !this.only[e.Conn] {
+			continue
+		}
+		if e.Type != EVENT_OPEN && e.Type != EVENT_CLOSE && e.Type != send {
+			continue
+		}
+		if !started {
+			first = e.Offset
+			started = true
+		}
+		if err := this.wait(ctx, start, e.Offset-first); err != nil {
+			this.closeAll()
+			return err
+		}
+		switch e.Type {
+		case EVENT_OPEN:
+			this.open(ctx, e.Conn)
+		case EVENT_CLOSE:
+			//	快进时保留连接到最后	以便收齐应答
+			if this.opts.Speed > 0 {
+				this.close(e.Conn)
+			}
+		default:
+			this.send(e.Conn, e.Frame)
+		}
+	}
+
+	//	等待剩余的应答
+	timer := time.NewTimer(this.opts.Linger)
+	select {
+	case <-ctx.Done():
+	case <-timer.C:
+	}
+	timer.Stop()
+	this.closeAll()
+	return nil
+}
+
+// 把录制的会话回放到address上的服务器	每个录制的连接对应一个新的QClient
+func Replay(ctx context.Context, r io.Reader, address string, opts ReplayOptions) (ReplayStats, error) {
+	if opts.Linger <= 0 {
+		opts.Linger = DEFAULT_LINGER
+	}
+	reader, err := NewReader(r)
+	if err != nil {
+		return ReplayStats{}, err
+	}
+	rp := &replayer{
+		address: address,
+		opts:    opts,
+		only:    make(map[uint64]bool),
+		clients: make(map[uint64]*client.QClient),
+	}
+	for _, id := range opts.Conns {
+		rp.only[id] = true
+	}
+	err = rp.run(ctx, reader)
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+	return rp.stats, err
+}
+
+func ReplayFile(ctx context.Context, path string, address string, opts ReplayOptions) (ReplayStats, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return ReplayStats{}, err
+	}
+	defer f.Close()
+	return Replay(ctx, f, address, opts)
+}
@@ -0,0 +1,69 @@
+package rpc
+
+import (
+	"wwt/net/peer"
+	"wwt/net/server"
+)
+
+// 请求/应答帧格式
+// | 'Q' | 'R' | kind(1) | id(4) | body |
+// 帧格式的实现位于peer	此处保留对外的名字
+const (
+	RPC_MAGIC0 = peer.RPC_MAGIC0
+	RPC_MAGIC1 = peer.RPC_MAGIC1
+
+	KIND_REQUEST  = peer.KIND_REQUEST
+	KIND_RESPONSE = peer.KIND_RESPONSE
+	KIND_ERROR    = peer.KIND_ERROR
+
+	HEADER_SIZE = peer.RPC_HEADER_SIZE
+)
+
+var ErrConnectionClosed = peer.ErrConnectionClosed
+var ErrCallTimeout = peer.ErrCallTimeout
+var ErrRPCDisabled = peer.ErrRPCDisabled
+
+// 远端返回的错误
+type RemoteError = peer.RemoteError
+
+type Frame = peer.RPCFrame
+
+type Request = peer.RPCRequest
+
+// 可以写出数据帧的对象	TokenHandler与ClientHandler均满足
+type Writer = peer.RPCWriter
+
+// 处理请求	返回的数据作为应答	返回error时回复错误帧
+//
+// Deprecated: 使用 server.RPCHandleFunc
+type HandleFunc = server.RPCHandleFunc
+
+func Encode(kind byte, id uint32, body []byte) []byte {
+	return peer.EncodeRPC(kind, id, body)
+}
+
+func Decode(b []byte) (*Frame, bool) {
+	return peer.DecodeRPC(b)
+}
+
+// 解析请求帧	不是请求帧时返回false
+func ParseRequest(b []byte) (*Request, bool) {
+	return peer.ParseRPCRequest(b)
+}
+
+// 回复指定请求
+func Reply(w Writer, req *Request, payload []byte) {
+	peer.ReplyRPC(w, req, payload)
+}
+
+// 以错误回复指定请求
+func ReplyError(w Writer, req *Request, msg string) {
+	peer.ReplyRPCError(w, req, msg)
+}
+
+// 包装ProcesseFunc	请求帧交给handle处理并自动回复	其他数据帧交给fallback
+//
+// Deprecated: 使用 server.RPCProcesser
+func Serve(handle HandleFunc, fallback server.ProcesseFunc) server.ProcesseFunc {
+	return server.RPCProcesser(handle, fallback)
+}
@@ -1,16 +1,16 @@
 package connection
 
 import (
+	"context"
 	"net"
-	"wwt/util"
 	"sync"
-	"wwt/ctrl"
+	"wwt/net/peer"
 )
 
 const (
-	BUFFER_SIZE = 2048
-	RCHAN_SIZE  = 1024
-	WCHAN_SIZE  = 1024
+	BUFFER_SIZE = peer.BUFFER_SIZE
+	RCHAN_SIZE  = peer.RCHAN_SIZE
+	WCHAN_SIZE  = peer.WCHAN_SIZE
 )
 
 type ReadCallback func(TokenHandler, int, []byte)
@@ -83,201 +83,30 @@ type TokenHandler interface {
 
 	Write([]byte)
 
+	//	向客户端发送请求并等待对应的应答
+	Call(ctx context.Context, payload []byte) ([]byte, error)
+
 	IsClosed() bool
 }
 
-type RChan chan []byte
-type WChan chan []byte
+type RChan = peer.RChan
+type WChan = peer.WChan
 
+// 服务端连接	读写逻辑由peer.QPeer实现
 type QToken struct {
-	conn    net.Conn
-	onRead  ReadCallback
-	onClose CloseCallback
-
-	r_exit   chan struct{}
-	r_stream util.StreamBuffer
-	r_chan   RChan
-
-	w_exit chan struct{}
-	w_chan WChan
-
-	task_group sync.WaitGroup
-	close_once sync.Once
-
-	closed	bool
+	*peer.QPeer
 }
 
-func (this *QToken) Write(b []byte) {
-	defer func() {
-		_ = recover()
-	}()
-	select {
-	case <-this.w_exit:
-		return
-	default:
-		this.w_chan <- b
-	}
-
-}
-
-func (this *QToken) sendAsync() {
-	defer func() {
-		this.task_group.Done()
-		_ = recover() //	捕获异常	改层易触发conn close 异常
-		this.Close()
-	}()
-
-	for {
-		select {
-		case <-this.w_exit:
-			panic(nil)
-			return
-		case b := <-this.w_chan:
-			if b != nil {
-				stream := util.NewStreamBuffer()
-				stream.WriteInt(len(b))
-				stream.Append(b)
-				n, err := this.conn.Write(stream.Bytes())
-				if n <= 0 || err != nil {
-					panic(err)
-					return
-				}
-			} else {
-				return
-			}
-		}
-	}
-}
-
-func (this *QToken) StartSend() {
-	ctrl.StartGoroutines(func() {
-		this.sendAsync()
-	})
-}
-
-func (this *QToken) read(b []byte) (int, error) {
-	return this.conn.Read(b)
-}
-
-func (this *QToken) RemoteAddr() net.Addr {
-	return this.conn.RemoteAddr()
-}
-
-func (this *QToken) readAsync() {
-	defer func() {
-		this.task_group.Done()
-		_ = recover()
-		this.Close()
-	}()
-
-	for {
-		select {
-		case <-this.r_exit:
-			panic(nil)
-			return
-		default:
-			b := make([]byte, BUFFER_SIZE)
-			n, err := this.conn.Read(b) //	可引发连接异常
-			if n <= 0 || err != nil {
-				panic(err)
-				return
-			}
-			this.r_chan <- b[:n]
-		}
-
-	}
-}
-
-func (this *QToken) StartRead() {
-	ctrl.StartGoroutines(func() {
-		this.processRead()
-	})
-	ctrl.StartGoroutines(func() {
-		this.readAsync()
-	})
-}
-
-func (this *QToken) processRead() {
-	defer func() {
-		this.task_group.Done()
-		_ = recover() //	捕获异常
-		//	TODO::Nothing
-	}()
-	for {
-		select {
-		case <-this.r_exit:
-			panic(nil)
-			return
-		case b := <-this.r_chan:
-			if b != nil {
-				this.r_stream.Append(b)
-				for this.r_stream.Len() > 4 {
-					length := this.r_stream.ReadInt()
-					//	数据包已经完整
-					if !this.r_stream.Empty() && length <= this.r_stream.Len() {
-						data := this.r_stream.ReadNBytes(length)
-						this.onRead(this, length, data)
-					} else {
-						this.r_stream.Undo()
-						break
-					}
-				}
-			} else {
-				panic(nil)
-				return
-			}
-
-		}
-	}
-
-}
-
-func (this *QToken)IsClosed()bool{
-	return this.closed
+func NewQToken(conn net.Conn, onRead ReadCallback, onClose CloseCallback) *QToken {
+	return NewQTokenWithCodec(conn, peer.DefaultFrameCodec(), onRead, onClose)
 }
 
-func (this *QToken) Close() {
-	this.close_once.Do(func() {
-		close(this.r_exit) //	关闭对远端数据流的处理		影响到processRead方法		放弃从管道中读入数据并退出
-		close(this.w_exit) //	对上层应用关闭输入口	影响到Write方法	针对准备写入数据时被阻塞的goroutine
-		this.conn.Close()  //	关闭连接，readAsync,sendAsync会触发异常并退出
-		////	清理w_chan
-		//ctrl.StartGoroutines(func() {
-		//	for _ = range this.w_chan {
-		//
-		//	}
-		//})
-		//
-		////	清理r_chan
-		//ctrl.StartGoroutines(func() {
-		//	for _ = range this.r_chan {
-		//
-		//	}
-		//})
-		this.task_group.Wait() //	等待该客户端所有任务	goroutuines	退出
-
-		close(this.r_chan)     //	关闭处理数据流管道
-		close(this.w_chan)     //	关闭发送数据流管道
-		this.onClose(this)
-		this.closed=true
+func NewQTokenWithCodec(conn net.Conn, codec peer.FrameCodec, onRead ReadCallback, onClose CloseCallback) *QToken {
+	token := &QToken{}
+	token.QPeer = peer.NewQPeerWithCodec(conn, codec, func(n int, b []byte) {
+		onRead(token, n, b)
+	}, func() {
+		onClose(token)
 	})
-
-}
-
-func NewQToken(conn net.Conn, onRead ReadCallback, onClose CloseCallback) *QToken {
-	token := QToken{
-		conn,
-		onRead,
-		onClose,
-		make(chan struct{}),
-		util.NewStreamBuffer(),
-		make(RChan, RCHAN_SIZE),
-		make(chan struct{}),
-		make(WChan, WCHAN_SIZE),
-		sync.WaitGroup{},
-		sync.Once{},
-		 false,
-	}
-	token.task_group.Add(3)
-	return &token
+	return token
 }
@@ -0,0 +1,262 @@
+package listener
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	DEFAULT_MAX_CONN_PER_IP = 0 //	0 表示不限制
+	FILTER_SWEEP_INTERVAL   = time.Minute
+)
+
+// 连接准入控制
+// 在创建QToken之前检查来源地址	被拒绝的连接只消耗一次Accept与Close
+type IPFilterHandler interface {
+	//	增加/移除白名单	白名单非空时只有命中的地址可以连接
+	Allow(cidr string) error
+	RemoveAllow(cidr string)
+
+	//	增加/移除黑名单	黑名单优先于白名单
+	Deny(cidr string) error
+	RemoveDeny(cidr string)
+
+	//	单个IP的最大连接数	n<=0 表示不限制
+	SetMaxConnPerIP(n int)
+
+	//	单个IP每秒允许的Accept次数与突发上限	rate<=0 表示不限制
+	SetAcceptRate(rate float64, burst int)
+
+	//	判断是否接受该地址的连接	接受时占用一个连接计数
+	Admit(ip net.IP) bool
+
+	//	连接关闭时归还连接计数
+	Release(ip net.IP)
+
+	//	当前该IP的连接数
+	ConnCount(ip net.IP) int
+}
+
+type ipState struct {
+	conns  int
+	tokens float64
+	last   time.Time
+}
+
+type IPFilter struct {
+	mu sync.Mutex
+
+	allow map[string]*net.IPNet
+	deny  map[string]*net.IPNet
+
+	maxConn int
+	rate    float64
+	burst   int
+
+	states    map[string]*ipState
+	lastSweep time.Time
+}
+
+func parseCIDR(cidr string) (string, *net.IPNet, error) {
+	//	兼容单个IP地址
+	if ip := net.ParseIP(cidr); ip != nil {
+		if ip.To4() != nil {
+			cidr = ip.String() + "/32"
+		} else {
+			cidr = ip.String() + "/128"
+		}
+	}
+	_, ipnet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return "", nil, err
+	}
+	return ipnet.String(), ipnet, nil
+}
+
+func matchAny(nets map[string]*net.IPNet, ip net.IP) bool {
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func (this *IPFilter) Allow(cidr string) error {
+	key, ipnet, err := parseCIDR(cidr)
+	if err != nil {
+		return err
+	}
+	this.mu.Lock()
+	this.allow[key] = ipnet
+	this.mu.Unlock()
+	return nil
+}
+
+func (this *IPFilter) RemoveAllow(cidr string) {
+	key, _, err := parseCIDR(cidr)
+	if err != nil {
+		return
+	}
+	this.mu.Lock()
+	delete(this.allow, key)
+	this.mu.Unlock()
+}
+
+func (this *IPFilter) Deny(cidr string) error {
+	key, ipnet, err := parseCIDR(cidr)
+	if err != nil {
+		return err
+	}
+	this.mu.Lock()
+	this.deny[key] = ipnet
+	this.mu.Unlock()
+	return nil
+}
+
+func (this *IPFilter) RemoveDeny(cidr string) {
+	key, _, err := parseCIDR(cidr)
+	if err != nil {
+		return
+	}
+	this.mu.Lock()
+	delete(this.deny, key)
+	this.mu.Unlock()
+}
+
+func (this *IPFilter) SetMaxConnPerIP(n int) {
+	this.mu.Lock()
+	this.maxConn = n
+	this.mu.Unlock()
+}
+
+func (this *IPFilter) SetAcceptRate(rate float64, burst int) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	if burst < 1 {
+		burst = 1
+	}
+	this.rate = rate
+	this.burst = burst
+	for _, s := range this.states {
+		if s.tokens > float64(burst) {
+			s.tokens = float64(burst)
+		}
+	}
+}
+
+func (this *IPFilter) Admit(ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	if matchAny(this.deny, ip) {
+		return false
+	}
+	if len(this.allow) > 0 && !matchAny(this.allow, ip) {
+		return false
+	}
+
+	now := time.Now()
+	this.sweep(now)
+
+	key := ip.String()
+	s, ok := this.states[key]
+	if !ok {
+		s = &ipState{0, float64(this.burst), now}
+		this.states[key] = s
+	}
+
+	//	令牌桶限速
+	if this.rate > 0 {
+		s.tokens += now.Sub(s.last).Seconds() * this.rate
+		if s.tokens > float64(this.burst) {
+			s.tokens = float64(this.burst)
+		}
+		s.last = now
+		if s.tokens < 1 {
+			return false
+		}
+	}
+
+	if this.maxConn > 0 && s.conns >= this.maxConn {
+		return false
+	}
+
+	if this.rate > 0 {
+		s.tokens--
+	}
+	s.conns++
+	return true
+}
+
+func (this *IPFilter) Release(ip net.IP) {
+	if ip == nil {
+		return
+	}
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	if s, ok := this.states[ip.String()]; ok && s.conns > 0 {
+		s.conns--
+	}
+}
+
+func (this *IPFilter) ConnCount(ip net.IP) int {
+	if ip == nil {
+		return 0
+	}
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	if s, ok := this.states[ip.String()]; ok {
+		return s.conns
+	}
+	return 0
+}
+
+//	清理没有连接且令牌已回满的IP记录	调用者持有锁
+func (this *IPFilter) sweep(now time.Time) {
+	if now.Sub(this.lastSweep) < FILTER_SWEEP_INTERVAL {
+		return
+	}
+	this.lastSweep = now
+	for k, s := range this.states {
+		if s.conns > 0 {
+			continue
+		}
+		if this.rate > 0 && s.tokens+now.Sub(s.last).Seconds()*this.rate < float64(this.burst) {
+			continue
+		}
+		delete(this.states, k)
+	}
+}
+
+func NewIPFilter() IPFilterHandler {
+	return &IPFilter{
+		allow:     make(map[string]*net.IPNet),
+		deny:      make(map[string]*net.IPNet),
+		maxConn:   DEFAULT_MAX_CONN_PER_IP,
+		burst:     1,
+		states:    make(map[string]*ipState),
+		lastSweep: time.Now(),
+	}
+}
+
+//	从连接地址中取出IP
+func addrIP(addr net.Addr) net.IP {
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		return a.IP
+	case *net.UDPAddr:
+		return a.IP
+	case nil:
+		return nil
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return net.ParseIP(addr.String())
+	}
+	return net.ParseIP(host)
+}
@@ -1,7 +1,9 @@
 package listener
 
 import (
+	"crypto/tls"
 	"net"
+	"sync"
 	"wwt/ctrl"
 	"log"
 )
@@ -22,22 +24,32 @@ type ListenerHandle interface{
 	AsyncAccept(onAccept AcceptFunc)
 	SyncAccept(onAccept AcceptFunc)
 	Close()
-	ReleaseConn()
+	ReleaseConn(addr net.Addr)
+	Filter() IPFilterHandler
 }
 
 type QListener struct {
-	listener net.Listener
+	listener   net.Listener
+	filter     IPFilterHandler
+	close_once sync.Once
 }
 
 func (this *QListener)Close(){
-	this.listener.Close()
-	log.Println("QListener:Close listener.")
+	this.close_once.Do(func() {
+		this.listener.Close()
+		log.Println("QListener:Close listener.")
+	})
 }
 
-func (this *QListener)ReleaseConn(){
+func (this *QListener)ReleaseConn(addr net.Addr){
+	this.filter.Release(addrIP(addr))
 	<-connLimitChanel
 }
 
+func (this *QListener)Filter() IPFilterHandler{
+	return this.filter
+}
+
 
 func (this *QListener)accept(onAccept AcceptFunc) {
 
@@ -47,6 +59,12 @@ func (this *QListener)accept(onAccept AcceptFunc) {
 		if err != nil {
 			break
 		}
+		//	准入检查	被拒绝的连接直接关闭并归还连接数
+		if !this.filter.Admit(addrIP(conn.RemoteAddr())) {
+			conn.Close()
+			<-connLimitChanel
+			continue
+		}
 		ctrl.StartGoroutines(func() {
 			onAccept(conn)
 		})
@@ -64,11 +82,21 @@ func (this *QListener) SyncAccept(onAccept AcceptFunc)  {
 }
 
 func NewListener(address string) ListenerHandle {
-	listener := QListener{}
-	l, err := net.Listen("tcp", address)
+	listener, err := Listen(address, nil)
 	if err != nil {
 		panic(err)
 	}
-	listener.listener = l
-	return &listener
+	return listener
+}
+
+// 监听地址	config不为nil时在TCP之上做TLS握手
+func Listen(address string, config *tls.Config) (ListenerHandle, error) {
+	l, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	if config != nil {
+		l = tls.NewListener(l, config)
+	}
+	return &QListener{listener: l, filter: NewIPFilter()}, nil
 }
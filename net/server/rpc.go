@@ -0,0 +1,29 @@
+package server
+
+import (
+	"wwt/net/peer"
+	"wwt/net/server/connection"
+)
+
+// 处理请求	返回的数据作为应答	返回error时回复错误帧
+type RPCHandleFunc func(token connection.TokenHandler, req *peer.RPCRequest) ([]byte, error)
+
+// 包装ProcesseFunc	请求帧交给handle处理并自动回复	其他数据帧交给fallback
+// 使用该函数即表示该服务端的数据帧按请求/应答格式识别
+func RPCProcesser(handle RPCHandleFunc, fallback ProcesseFunc) ProcesseFunc {
+	return func(token connection.TokenHandler, n int, b []byte) {
+		req, ok := peer.ParseRPCRequest(b)
+		if !ok {
+			if fallback != nil {
+				fallback(token, n, b)
+			}
+			return
+		}
+		res, err := handle(token, req)
+		if err != nil {
+			peer.ReplyRPCError(token, req, err.Error())
+			return
+		}
+		peer.ReplyRPC(token, req, res)
+	}
+}
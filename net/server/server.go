@@ -1,12 +1,16 @@
 package server
 
 import (
+	"context"
+	"crypto/tls"
 	"wwt/net/server/listener"
 	"wwt/net/server/connection"
+	"wwt/net/peer"
 	"wwt/ctrl"
 	"time"
 	"net"
 	"log"
+	"fmt"
 )
 
 type QServerHandle interface {
@@ -16,13 +20,45 @@ type QServerHandle interface {
 
 	Close()
 
+	//	停止接受新连接	等待已有连接断开	ctx结束时关闭剩余连接并返回ctx的错误
+	Shutdown(ctx context.Context) error
+
+	//	当前连接数
+	Connections() int
+
 	SetProcesser(ProcesseFunc)
 
+	//	连接关闭时的回调	需在Listen之前调用
+	SetCloseHandler(CloseHandler)
+
 	HeartbeatStart()
+
+	//	设置心跳间隔	需在HeartbeatStart之前调用
+	SetHeartbeatInterval(d time.Duration)
+
+	Filter() listener.IPFilterHandler
+
+	//	设置帧格式	需在Listen之前调用
+	SetFrameCodec(codec peer.FrameCodec)
+
+	//	设置原始连接处理函数	设置后不再创建Token	连接交给handler直到其返回
+	SetRawHandler(handler RawHandler)
+
+	//	录制每个连接上的帧	需在Listen之前调用
+	SetRecorder(recorder peer.TapFactory)
+
+	//	开启请求/应答帧	Token可以向客户端发起Call	需在Listen之前调用
+	//	处理客户端的请求需同时使用RPCProcesser
+	SetRPC(enable bool)
 }
 
 type ProcesseFunc func(connection.TokenHandler, int, []byte)
 
+type CloseHandler func(connection.TokenHandler)
+
+// 处理不分帧的原始连接	返回时连接应已关闭
+type RawHandler func(conn net.Conn)
+
 type QWriter interface {
 	Send([]byte)
 }
@@ -31,13 +67,47 @@ type QServer struct {
 	listener     listener.ListenerHandle
 	tokens       connection.TokenPoolHandler
 	processeFunc ProcesseFunc
+	codec        peer.FrameCodec
+	rawHandler   RawHandler
+	closeHandler CloseHandler
+	recorder     peer.TapFactory
+	rpc          bool
+	heartbeat    time.Duration
 	closed       bool
+	unregister   func()
 }
 
+const (
+	DEFAULT_HEARTBEAT_INTERVAL = time.Minute * 10
+	SHUTDOWN_POLL_INTERVAL     = time.Millisecond * 100
+)
+
 func (this *QServer) Close() {
 	this.tokens.CloseAll()
 	this.listener.Close()
 	this.closed = true
+	this.unregister()
+}
+
+func (this *QServer) Shutdown(ctx context.Context) error {
+	this.listener.Close()
+	ticker := time.NewTicker(SHUTDOWN_POLL_INTERVAL)
+	defer ticker.Stop()
+	for this.tokens.Len() > 0 {
+		select {
+		case <-ctx.Done():
+			log.Printf("QServer %p: Shutdown timeout, close %d connections.\n", this, this.tokens.Len())
+			this.Close()
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+	this.Close()
+	return nil
+}
+
+func (this *QServer) Connections() int {
+	return this.tokens.Len()
 }
 
 func (this *QServer) AsyncListen() {
@@ -49,7 +119,19 @@ func (this *QServer) SyncListen() {
 }
 
 func (this *QServer) onAccept(conn net.Conn) {
-	token := connection.NewQToken(conn, this.onRead, this.onClose)
+	if this.rawHandler != nil {
+		this.rawHandler(conn)
+		conn.Close()
+		this.listener.ReleaseConn(conn.RemoteAddr())
+		return
+	}
+	token := connection.NewQTokenWithCodec(conn, this.codec, this.onRead, this.onClose)
+	if this.recorder != nil {
+		token.SetFrameTap(this.recorder.Open(conn))
+	}
+	if this.rpc {
+		token.EnableRPC()
+	}
 	this.tokens.AddToken(token)
 	token.StartRead()
 	token.StartSend()
@@ -60,15 +142,48 @@ func (this *QServer) onRead(handle connection.TokenHandler, n int, bytes []byte)
 	this.processeFunc(handle, n, bytes)
 }
 
+func (this *QServer) Filter() listener.IPFilterHandler {
+	return this.listener.Filter()
+}
+
+func (this *QServer) SetFrameCodec(codec peer.FrameCodec) {
+	this.codec = codec
+}
+
+func (this *QServer) SetRawHandler(handler RawHandler) {
+	this.rawHandler = handler
+}
+
 func (this *QServer) SetProcesser(p ProcesseFunc) {
 	this.processeFunc = p
 }
 
+func (this *QServer) SetRecorder(recorder peer.TapFactory) {
+	this.recorder = recorder
+}
+
+func (this *QServer) SetRPC(enable bool) {
+	this.rpc = enable
+}
+
+func (this *QServer) SetCloseHandler(h CloseHandler) {
+	this.closeHandler = h
+}
+
+func (this *QServer) SetHeartbeatInterval(d time.Duration) {
+	if d > 0 {
+		this.heartbeat = d
+	}
+}
+
 func (this *QServer) onClose(handle connection.TokenHandler) {
 	//TODO::关闭TOKEN
 	//handle.Close()
 	this.tokens.DeleteToken(handle)
-	this.listener.ReleaseConn()
+	this.listener.ReleaseConn(handle.RemoteAddr())
+	if this.closeHandler != nil {
+		this.closeHandler(handle)
+	}
 	log.Printf("QServer %p: Delete token %p. Remain: %d.\n", this, &handle, this.tokens.Len())
 	//fmt.Println("Remain:",this.tokens.Len())
 }
@@ -77,7 +192,7 @@ func (this *QServer)HeartbeatStart() {
 	ctrl.StartGoroutines(func() {
 		beatpkg := make([]byte,0)
 		for !this.closed{
-			time.Sleep(time.Minute*10)
+			time.Sleep(this.heartbeat)
 			if this.closed{
 				break
 			}
@@ -89,9 +204,38 @@ func (this *QServer)HeartbeatStart() {
 
 }
 
+// 作为ctrl.App的组件	Start开始监听	Stop等待连接断开	超时后强制关闭
+func AsComponent(s QServerHandle) ctrl.Component {
+	return ctrl.Hooks{
+		OnStart: func(context.Context) error {
+			s.AsyncListen()
+			return nil
+		},
+		OnStop: s.Shutdown,
+	}
+}
+
 func NewQServer(address string) QServerHandle {
+	qserver, err := Listen(address, nil)
+	if err != nil {
+		panic(err)
+	}
+	return qserver
+}
+
+// 监听地址	config不为nil时使用TLS
+func Listen(address string, config *tls.Config) (QServerHandle, error) {
+	l, err := listener.Listen(address, config)
+	if err != nil {
+		return nil, err
+	}
 	qserver := new(QServer)
-	qserver.listener = listener.NewListener(address)
+	qserver.listener = l
 	qserver.tokens = connection.NewTokenPool()
-	return qserver
+	qserver.codec = peer.DefaultFrameCodec()
+	qserver.heartbeat = DEFAULT_HEARTBEAT_INTERVAL
+	qserver.unregister = ctrl.RegisterStats("QServer "+address, func() string {
+		return fmt.Sprintf("%d connections", qserver.tokens.Len())
+	})
+	return qserver, nil
 }
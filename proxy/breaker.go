@@ -0,0 +1,39 @@
+package proxy
+
+import (
+	"log"
+	"wwt/util/breaker"
+)
+
+func (this *qproxy) SetBreaker(opts breaker.Options) {
+	this.mu.Lock()
+	this.breaker_opts = opts
+	this.mu.Unlock()
+}
+
+// 新后端的熔断配置	调用者持有锁
+func (this *qproxy) breakerOptions() breaker.Options {
+	opts := this.breaker_opts
+	if opts.Cooldown <= 0 {
+		opts.Cooldown = this.failover.RetryInterval
+	}
+	notify := opts.OnStateChange
+	opts.OnStateChange = func(addr string, from breaker.State, to breaker.State) {
+		log.Printf("QProxy: backend %s breaker %s -> %s.\n", addr, from, to)
+		if notify != nil {
+			notify(addr, from, to)
+		}
+	}
+	return opts
+}
+
+func (this *qproxy) BackendState(addr string) breaker.State {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	for _, b := range this.backends {
+		if b.addr == addr {
+			return b.breaker.State()
+		}
+	}
+	return breaker.CLOSED
+}
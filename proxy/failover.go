@@ -0,0 +1,166 @@
+package proxy
+
+import (
+	"log"
+	"time"
+	"wwt/ctrl"
+	"wwt/net/client"
+	"wwt/net/server/connection"
+)
+
+const (
+	DEFAULT_RETRY_INTERVAL = 5 * time.Second
+)
+
+// 后端连接丢失	principals 为受影响的被代理者
+type BackendLostCallback func(addr string, principals []connection.TokenHandler)
+
+// 被代理者被重新绑定到新连接后调用	用于向新后端重放登录等状态
+type ResyncCallback func(principal connection.TokenHandler, c client.ClientHandler, from string, to string)
+
+// 粘性会话的key	相同key的被代理者绑定到同一后端	返回空字符串表示不做粘性
+type StickyKeyFunc func(principal connection.TokenHandler) string
+
+// 故障转移配置
+type FailoverOptions struct {
+	Rebind        bool                //	后端故障时把被代理者重新绑定到其他可用后端
+	StickyKey     StickyKeyFunc       //	粘性会话的key
+	RetryInterval time.Duration       //	故障后端暂停分配的时长	熔断器未设置Cooldown时使用
+	OnBackendLost BackendLostCallback //	后端丢失通知
+	OnResync      ResyncCallback      //	重新绑定后的状态同步
+}
+
+func (this *FailoverOptions) normalize() {
+	if this.RetryInterval <= 0 {
+		this.RetryInterval = DEFAULT_RETRY_INTERVAL
+	}
+}
+
+func (this *qproxy) SetFailover(opts FailoverOptions) {
+	opts.normalize()
+	this.mu.Lock()
+	this.failover = opts
+	this.mu.Unlock()
+}
+
+// 确认后端宕机	立即打开熔断器
+func (this *qproxy) markDown(b *proxyBackend) {
+	b.breaker.Trip()
+	log.Printf("QProxy: backend %s is down.\n", b.addr)
+}
+
+// 被代理者的后端连接意外关闭
+func (this *qproxy) onBackendLost(b *proxyBackend, principal connection.TokenHandler) {
+	ctrl.StartGoroutines(func() {
+		this.failoverPrincipals(b, principal)
+	})
+}
+
+func (this *qproxy) failoverPrincipals(b *proxyBackend, principal connection.TokenHandler) {
+	//	先探测后端是否仍然可用	可用时只是单个连接断开	原地重连
+	//	探测拨号不持有锁	无响应的后端不会阻塞其他被代理者
+	this.mu.Lock()
+	if this.closed || this.nhook.HasKey(principal) {
+		this.mu.Unlock()
+		return
+	}
+	removed := b.removed
+	this.mu.Unlock()
+
+	var c client.ClientHandler
+	if !removed {
+		c = this.newConnection(b)
+	}
+
+	this.mu.Lock()
+	stale := make([]client.ClientHandler, 0)
+	if c != nil && !this.install(b, c) {
+		//	探测期间代理关闭或后端被移除	新连接随旧连接一起关闭
+		stale = append(stale, c)
+		c = nil
+	}
+	if this.closed {
+		this.mu.Unlock()
+		for _, c := range stale {
+			c.Close()
+		}
+		return
+	}
+	if c != nil {
+		if this.nhook.HasKey(principal) {
+			//	探测期间已被重新绑定	新连接留作闲置
+			b.idlec.Set(c, struct{}{})
+			this.mu.Unlock()
+			return
+		}
+		//	被代理者的路由没有丢失	不通知OnBackendLost
+		this.bind(principal, c, b)
+		this.mu.Unlock()
+		if this.failover.OnResync != nil {
+			this.failover.OnResync(principal, c, b.addr, b.addr)
+		}
+		return
+	}
+
+	//	后端不可用	解除该后端上所有被代理者的绑定
+	if !b.removed {
+		this.markDown(b)
+	}
+	affected := make([]connection.TokenHandler, 0)
+	if !this.nhook.HasKey(principal) {
+		affected = append(affected, principal)
+	}
+	this.nhook.NonBlockRange(func(k interface{}, v interface{}) {
+		if owner, ok := this.owner.Get(v).(*proxyBackend); ok && owner == b {
+			affected = append(affected, k.(connection.TokenHandler))
+			stale = append(stale, v.(client.ClientHandler))
+		}
+	})
+	for _, c := range stale {
+		p, ok := this.hook.Get(c).(connection.TokenHandler)
+		if !ok {
+			continue
+		}
+		this.nhook.Delete(p)
+		this.hook.Delete(c)
+		this.owner.Delete(c)
+		this.release(p)
+	}
+	b.idlec.NonBlockRange(func(k interface{}, _ interface{}) {
+		stale = append(stale, k.(client.ClientHandler))
+		this.owner.Delete(k)
+	})
+	b.idlec.Clear()
+	this.mu.Unlock()
+
+	//	旧连接已解除绑定	关闭时ProcessClose不会再次触发故障转移
+	for _, c := range stale {
+		c.Close()
+	}
+	this.notifyLost(b.addr, affected)
+
+	if !this.failover.Rebind {
+		return
+	}
+	for _, p := range affected {
+		if p.IsClosed() {
+			continue
+		}
+		this.AddPrincipal(p)
+		c, ok := this.nhook.Get(p).(client.ClientHandler)
+		if !ok {
+			continue
+		}
+		to := this.Backend(p)
+		log.Printf("QProxy: rebind %s from %s to %s.\n", p.RemoteAddr(), b.addr, to)
+		if this.failover.OnResync != nil {
+			this.failover.OnResync(p, c, b.addr, to)
+		}
+	}
+}
+
+func (this *qproxy) notifyLost(addr string, principals []connection.TokenHandler) {
+	if this.failover.OnBackendLost != nil {
+		this.failover.OnBackendLost(addr, principals)
+	}
+}
@@ -1,10 +1,15 @@
 package proxy
 
 import (
-	"wwt/util"
-	"wwt/net/client"
+	"hash/crc32"
 	"log"
+	"net"
+	"sort"
+	"sync"
+	"wwt/net/client"
 	"wwt/net/server/connection"
+	"wwt/util"
+	"wwt/util/breaker"
 )
 
 type ResponseCallback func(token connection.TokenHandler, n int, b []byte)
@@ -16,6 +21,15 @@ type ProxyHandle interface {
 	//	建立连接
 	Connect(addr string, count int, callback ResponseCallback)
 
+	//	设置远程消息的回调	不使用Connect而直接AddBackend时需先调用
+	SetResponseCallback(callback ResponseCallback)
+
+	//	增加后端服务器	并预先建立count个闲置连接
+	AddBackend(addr string, count int)
+
+	//	移除后端服务器	闲置连接立即关闭	使用中的连接在回收时关闭
+	RemoveBackend(addr string)
+
 	//	处理远程主机的返回消息
 	ProcessRemoteMessage(handler client.ClientHandler, n int, b []byte)
 
@@ -28,40 +42,112 @@ type ProxyHandle interface {
 	//	查看是否有连接
 	HasPrincipal(principal connection.TokenHandler) bool
 
+	//	被代理者当前绑定的后端地址	未绑定时返回空字符串
+	Backend(principal connection.TokenHandler) string
+
 	//	处理代理消息
 	ProcessProxyMessage(k connection.TokenHandler, stream util.StreamBuffer)
 
+	//	设置拨号参数	需在Connect之前调用
+	SetDialOptions(opts client.DialOptions)
+
+	//	设置后端故障转移与粘性会话	需在Connect之前调用
+	SetFailover(opts FailoverOptions)
+
+	//	设置每个后端的熔断器	需在Connect之前调用
+	SetBreaker(opts breaker.Options)
+
+	//	后端熔断器的状态	后端不存在时返回CLOSED
+	BackendState(addr string) breaker.State
+
 	//	关闭代理连接
 	Close()
 }
 
-type qproxy struct {
+// 后端服务器
+type proxyBackend struct {
+	addr string
+
 	//	空闲连接
 	idlec *util.QMap
 
-	//	被使用的连接
+	//	熔断器	打开期间不分配	冷却后放行探测连接
+	breaker *breaker.Breaker
+
+	//	已被移除	不再分配	回收的连接直接关闭
+	removed bool
+}
+
+func (this *proxyBackend) up() bool {
+	return this.breaker.Ready()
+}
+
+// 粘性会话记录	refs 为当前绑定中使用该key的被代理者数
+type stickyEntry struct {
+	addr string
+	refs int
+}
+
+type qproxy struct {
+	//	保护后端集合与绑定关系的复合操作
+	mu sync.Mutex
+
+	//	后端服务器	按地址排序
+	backends []*proxyBackend
+	next     int
+
+	//	被使用的连接	client -> principal
 	hook *util.QMap
 
-	//	逆向连接
+	//	逆向连接	principal -> client
 	nhook *util.QMap
 
+	//	连接所属后端	client -> *proxyBackend
+	owner *util.QMap
+
+	//	粘性会话	key -> 后端	最后一个使用该key的被代理者解除绑定时删除
+	affinity map[string]*stickyEntry
+
+	//	被代理者的粘性key	principal -> key
+	sticky map[connection.TokenHandler]string
+
 	//	远程服务器地址
 	remote_addr string
 
 	//	回调远程消息函数
 	response_callback ResponseCallback
+
+	//	拨号参数
+	dial_opts client.DialOptions
+
+	//	故障转移配置
+	failover FailoverOptions
+
+	//	熔断配置
+	breaker_opts breaker.Options
+
+	closed bool
 }
 
 func (this *qproxy) Close() {
-	for this.nhook.Length() > 0 {
-		c := this.nhook.GetAnyValue().(client.ClientHandler)
-		t := this.hook.Get(c).(connection.TokenHandler)
-		this.nhook.Delete(t)
-		this.hook.Delete(c)
-		c.Close()
+	this.mu.Lock()
+	this.closed = true
+	conns := make([]client.ClientHandler, 0, this.owner.Length())
+	this.owner.Range(func(k interface{}, _ interface{}) {
+		conns = append(conns, k.(client.ClientHandler))
+	})
+	this.owner.Clear()
+	this.hook.Clear()
+	this.nhook.Clear()
+	this.affinity = make(map[string]*stickyEntry)
+	this.sticky = make(map[connection.TokenHandler]string)
+	for _, b := range this.backends {
+		b.idlec.Clear()
 	}
-	for this.idlec.Length() > 0 {
-		c := this.idlec.GetAnyKey().(client.ClientHandler)
+	this.mu.Unlock()
+
+	//	关闭连接会回调ProcessClose	不能持有锁
+	for _, c := range conns {
 		c.Close()
 	}
 }
@@ -74,79 +160,332 @@ func (this *qproxy) HasPrincipal(principal connection.TokenHandler) bool {
 	}
 }
 
+func (this *qproxy) Backend(principal connection.TokenHandler) string {
+	c := this.nhook.Get(principal)
+	if c == nil {
+		return ""
+	}
+	if b, ok := this.owner.Get(c).(*proxyBackend); ok {
+		return b.addr
+	}
+	return ""
+}
+
 func (this *qproxy) ProcessProxyMessage(principal connection.TokenHandler, stream util.StreamBuffer) {
 	if !this.nhook.HasKey(principal) {
 		this.AddPrincipal(principal)
 	}
-	c := this.nhook.Get(principal).(client.ClientHandler)
+	c, ok := this.nhook.Get(principal).(client.ClientHandler)
+	if !ok {
+		log.Printf("QProxy.ProcessProxyMessage: no backend for %s, drop message.\n", principal.RemoteAddr())
+		return
+	}
 	c.Write(stream.Bytes())
 }
 
 func (this *qproxy) RecyclingConnection(principal connection.TokenHandler) {
+	this.mu.Lock()
 	c := this.nhook.Get(principal)
+	if c == nil {
+		this.mu.Unlock()
+		return
+	}
 	this.nhook.Delete(principal)
 	this.hook.Delete(c)
-	this.idlec.Set(c, struct{}{})
+	this.release(principal)
+	b, ok := this.owner.Get(c).(*proxyBackend)
+	if ok && !b.removed {
+		b.idlec.Set(c, struct{}{})
+		this.mu.Unlock()
+		return
+	}
+	//	后端已被移除	不再放回闲置连接
+	this.owner.Delete(c)
+	this.mu.Unlock()
+	c.(client.ClientHandler).Close()
 }
 
-func (this *qproxy) AddPrincipal(principal connection.TokenHandler) {
-	if this.idlec.Length() == 0 {
-		nc := this.newConnection()
-		this.idlec.Set(nc, struct{}{})
+func (this *qproxy) stickyKey(principal connection.TokenHandler) string {
+	if this.failover.StickyKey == nil {
+		return ""
+	}
+	return this.failover.StickyKey(principal)
+}
+
+// 为key选择后端	有粘性记录且后端可用时沿用	跳过tried中的后端	调用者持有锁
+func (this *qproxy) pickBackend(key string, tried map[*proxyBackend]bool) *proxyBackend {
+	if key != "" {
+		if e, ok := this.affinity[key]; ok {
+			for _, b := range this.backends {
+				if b.addr == e.addr && !tried[b] && b.up() {
+					return b
+				}
+			}
+		}
+	}
+	cands := make([]*proxyBackend, 0, len(this.backends))
+	for _, b := range this.backends {
+		if !tried[b] && b.up() {
+			cands = append(cands, b)
+		}
+	}
+	if len(cands) == 0 {
+		return nil
+	}
+	var res *proxyBackend
+	if key != "" {
+		res = cands[crc32.ChecksumIEEE([]byte(key))%uint32(len(cands))]
+	} else {
+		this.next++
+		res = cands[this.next%len(cands)]
+	}
+	return res
+}
+
+// 从后端取一个闲置连接	没有时返回nil	调用者持有锁
+func (this *qproxy) takeIdle(b *proxyBackend) client.ClientHandler {
+	if c := b.idlec.GetAnyKey(); c != nil {
+		b.idlec.Delete(c)
+		return c.(client.ClientHandler)
 	}
-	c := this.idlec.GetAnyKey()
-	this.idlec.Delete(c)
+	return nil
+}
+
+// 登记新建的连接	代理已关闭、后端已移除或连接已断开时返回false	调用者持有锁
+func (this *qproxy) install(b *proxyBackend, c client.ClientHandler) bool {
+	if this.closed || b.removed || c.IsClosed() {
+		return false
+	}
+	this.owner.Set(c, b)
+	return true
+}
+
+// 绑定被代理者与连接	记录粘性会话	调用者持有锁
+func (this *qproxy) bind(principal connection.TokenHandler, c client.ClientHandler, b *proxyBackend) {
 	this.hook.Set(c, principal)
 	this.nhook.Set(principal, c)
+	key := this.stickyKey(principal)
+	if key == "" {
+		return
+	}
+	e, ok := this.affinity[key]
+	if !ok {
+		e = &stickyEntry{}
+		this.affinity[key] = e
+	}
+	e.addr = b.addr
+	if _, ok := this.sticky[principal]; !ok {
+		this.sticky[principal] = key
+		e.refs++
+	}
+}
+
+// 被代理者解除绑定	没有其他被代理者使用该key时删除粘性记录	调用者持有锁
+func (this *qproxy) release(principal connection.TokenHandler) {
+	key, ok := this.sticky[principal]
+	if !ok {
+		return
+	}
+	delete(this.sticky, principal)
+	if e, ok := this.affinity[key]; ok {
+		e.refs--
+		if e.refs <= 0 {
+			delete(this.affinity, key)
+		}
+	}
+}
+
+// 拨号在锁外进行	一个无响应的后端不会阻塞其他被代理者与故障转移
+func (this *qproxy) AddPrincipal(principal connection.TokenHandler) {
+	tried := make(map[*proxyBackend]bool)
+	for {
+		this.mu.Lock()
+		if this.closed || this.nhook.HasKey(principal) {
+			this.mu.Unlock()
+			return
+		}
+		b := this.pickBackend(this.stickyKey(principal), tried)
+		if b == nil {
+			this.mu.Unlock()
+			break
+		}
+		c := this.takeIdle(b)
+		this.mu.Unlock()
+
+		fresh := c == nil
+		if fresh {
+			c = this.newConnection(b)
+		}
+		if c != nil {
+			this.mu.Lock()
+			switch {
+			case fresh && !this.install(b, c):
+				this.mu.Unlock()
+				c.Close()
+			case c.IsClosed():
+				//	取出的闲置连接在解锁期间断开	ProcessClose已清理	重新选择
+				this.mu.Unlock()
+				continue
+			case this.closed || this.nhook.HasKey(principal):
+				//	拨号期间已被其他调用绑定	连接放回闲置
+				b.idlec.Set(c, struct{}{})
+				this.mu.Unlock()
+				return
+			default:
+				this.bind(principal, c, b)
+				this.mu.Unlock()
+				return
+			}
+		}
+		//	连接失败已计入熔断器	本次改选其他后端
+		tried[b] = true
+	}
+	log.Printf("QProxy.AddPrincipal: no backend available for %s.\n", principal.RemoteAddr())
 }
 
 func (this *qproxy) ProcessRemoteMessage(c client.ClientHandler, n int, b []byte) {
-	token := this.hook.Get(c).(connection.TokenHandler)
+	token, ok := this.hook.Get(c).(connection.TokenHandler)
+	if !ok {
+		return
+	}
 	this.response_callback(token, n, b)
 }
 
 func (this *qproxy) ProcessClose(handler client.ClientHandler) {
-	if this.idlec.HasKey(handler) {
-		this.idlec.Delete(handler)
+	this.mu.Lock()
+	b, _ := this.owner.Get(handler).(*proxyBackend)
+	this.owner.Delete(handler)
+	if b != nil {
+		b.idlec.Delete(handler)
 	}
 	var token connection.TokenHandler
 	if this.hook.HasKey(handler) {
 		token = this.hook.Get(handler).(connection.TokenHandler)
 		this.hook.Delete(handler)
 	}
-	if this.nhook.HasKey(token) {
+	if token != nil && this.nhook.Get(token) == handler {
 		this.nhook.Delete(token)
+		this.release(token)
+	}
+	closed := this.closed
+	this.mu.Unlock()
+
+	if !closed && token != nil && b != nil {
+		this.onBackendLost(b, token)
 	}
 }
 
 func (this *qproxy) Connect(addr string, count int, callback ResponseCallback) {
 	this.remote_addr = addr
+	this.response_callback = callback
+	this.AddBackend(addr, count)
+}
+
+func (this *qproxy) SetResponseCallback(callback ResponseCallback) {
+	this.response_callback = callback
+}
+
+func (this *qproxy) AddBackend(addr string, count int) {
+	this.mu.Lock()
+	var b *proxyBackend
+	for _, v := range this.backends {
+		if v.addr == addr {
+			b = v
+			break
+		}
+	}
+	if b == nil {
+		b = &proxyBackend{addr: addr, idlec: util.NewQMap(), breaker: breaker.New(addr, this.breakerOptions())}
+		this.backends = append(this.backends, b)
+		sort.Slice(this.backends, func(i, j int) bool {
+			return this.backends[i].addr < this.backends[j].addr
+		})
+	}
+	this.mu.Unlock()
+
 	cnt := 0
-	this.idlec = util.NewQMap()
-	this.hook = util.NewQMap()
-	this.nhook = util.NewQMap()
 	for i := 0; i < count; i++ {
-		c := this.newConnection()
-		if c != nil {
-			this.idlec.Set(c, struct{}{})
+		c := this.newConnection(b)
+		if c == nil {
+			continue
+		}
+		this.mu.Lock()
+		ok := this.install(b, c)
+		if ok {
+			b.idlec.Set(c, struct{}{})
 			cnt++
 		}
+		this.mu.Unlock()
+		if !ok {
+			c.Close()
+		}
+	}
+	log.Printf("QProxy.Connect: make %d idle connections to %s.\n", cnt, addr)
+}
 
+func (this *qproxy) RemoveBackend(addr string) {
+	this.mu.Lock()
+	var b *proxyBackend
+	for i, v := range this.backends {
+		if v.addr == addr {
+			b = v
+			this.backends = append(this.backends[:i], this.backends[i+1:]...)
+			break
+		}
 	}
-	this.response_callback = callback
-	log.Printf("QProxy.Connect: make %d idle connections.\n", cnt)
+	if b == nil {
+		this.mu.Unlock()
+		return
+	}
+	b.removed = true
+	idle := make([]client.ClientHandler, 0, b.idlec.Length())
+	b.idlec.NonBlockRange(func(k interface{}, _ interface{}) {
+		idle = append(idle, k.(client.ClientHandler))
+		this.owner.Delete(k)
+	})
+	b.idlec.Clear()
+	this.mu.Unlock()
+
+	//	闲置连接已解除归属	关闭时ProcessClose不会触发故障转移
+	for _, c := range idle {
+		c.Close()
+	}
+	log.Printf("QProxy: remove backend %s, close %d idle connections.\n", addr, len(idle))
 }
 
-func (this *qproxy) newConnection() client.ClientHandler {
-	c := client.QClient{}
-	err := c.Dial(this.remote_addr, this.ProcessRemoteMessage, this.ProcessClose)
+func (this *qproxy) SetDialOptions(opts client.DialOptions) {
+	this.dial_opts = opts
+}
+
+// 新建到后端的连接	熔断器打开时直接返回nil	不持有锁调用	成功后需install
+func (this *qproxy) newConnection(b *proxyBackend) client.ClientHandler {
+	if !b.breaker.Allow() {
+		return nil
+	}
+	c := &client.QClient{}
+	c.SetDialOptions(this.dial_opts)
+	err := c.Dial(b.addr, this.ProcessRemoteMessage, this.ProcessClose)
 	if err != nil {
 		log.Printf("QProxy.newConnection: create connection fail. %s.\n", err.Error())
+		if ne, ok := err.(net.Error); ok && ne.Timeout() {
+			b.breaker.Timeout()
+		} else {
+			b.breaker.Failure()
+		}
 		return nil
 	}
-	return &c
+	b.breaker.Success()
+	return c
 }
 
 func NewProxy() ProxyHandle {
-	return &qproxy{}
+	p := &qproxy{
+		hook:     util.NewQMap(),
+		nhook:    util.NewQMap(),
+		owner:    util.NewQMap(),
+		affinity: make(map[string]*stickyEntry),
+		sticky:   make(map[connection.TokenHandler]string),
+	}
+	p.failover.normalize()
+	return p
 }
@@ -0,0 +1,250 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+	"wwt/ctrl"
+	"wwt/net/client"
+)
+
+const (
+	DEFAULT_RAW_IDLE_TIMEOUT = 5 * time.Minute
+	RAW_BUFFER_SIZE          = 32 * 1024
+)
+
+var ErrIdleTimeout = errors.New("proxy: raw session idle timeout")
+
+// 透传会话结束时的统计
+type RawSessionStats struct {
+	Client    net.Addr
+	Backend   net.Addr
+	BytesUp   int64 //	客户端 -> 后端
+	BytesDown int64 //	后端 -> 客户端
+	Duration  time.Duration
+	Err       error //	非正常结束的原因
+}
+
+type RawSessionCallback func(stats RawSessionStats)
+
+// 透传配置	零值字段使用默认值
+type RawOptions struct {
+	Dial        client.DialOptions //	连接后端的拨号参数
+	IdleTimeout time.Duration      //	双向都没有数据时关闭会话	<0 表示不限制
+	OnClose     RawSessionCallback //	会话结束回调
+}
+
+// 透传状态
+type RawStats struct {
+	Sessions  int64 //	累计会话数
+	Active    int64 //	当前会话数
+	Failed    int64 //	连接后端失败次数
+	BytesUp   int64 //	累计 客户端 -> 后端 字节数
+	BytesDown int64 //	累计 后端 -> 客户端 字节数
+}
+
+// 原始字节流透传	不解析QNet帧	用于代理其他协议的旧服务
+// Serve 可以作为 listener.AcceptFunc 或 server.RawHandler 使用
+type RawProxyHandle interface {
+	//	处理一个客户端连接	阻塞到会话结束
+	Serve(conn net.Conn)
+
+	//	修改后端地址	只影响新会话
+	SetRemote(addr string)
+
+	Stats() RawStats
+
+	//	关闭所有会话
+	Close()
+}
+
+type rawproxy struct {
+	opts RawOptions
+
+	mu       sync.Mutex
+	remote   string
+	sessions map[*rawSession]struct{}
+	closed   bool
+
+	stats RawStats
+}
+
+type rawSession struct {
+	client  net.Conn
+	backend net.Conn
+	last    int64 //	最后一次收到数据的时间	UnixNano
+	up      int64
+	down    int64
+
+	idle_once sync.Once
+	idle      bool
+}
+
+func (this *rawproxy) SetRemote(addr string) {
+	this.mu.Lock()
+	this.remote = addr
+	this.mu.Unlock()
+}
+
+func (this *rawproxy) Serve(conn net.Conn) {
+	this.mu.Lock()
+	if this.closed {
+		this.mu.Unlock()
+		conn.Close()
+		return
+	}
+	remote := this.remote
+	this.mu.Unlock()
+
+	start := time.Now()
+	backend, err := client.DialRaw(context.Background(), remote, this.opts.Dial)
+	if err != nil {
+		atomic.AddInt64(&this.stats.Failed, 1)
+		log.Printf("RawProxy %p: Dial %s fail. %s.\n", this, remote, err.Error())
+		conn.Close()
+		return
+	}
+
+	s := &rawSession{client: conn, backend: backend, last: start.UnixNano()}
+	this.mu.Lock()
+	if this.closed {
+		this.mu.Unlock()
+		conn.Close()
+		backend.Close()
+		return
+	}
+	this.sessions[s] = struct{}{}
+	this.mu.Unlock()
+	atomic.AddInt64(&this.stats.Sessions, 1)
+	atomic.AddInt64(&this.stats.Active, 1)
+
+	errc := make(chan error, 2)
+	ctrl.StartGoroutines(func() {
+		errc <- this.pipe(s, backend, conn, &s.up, &this.stats.BytesUp)
+	})
+	ctrl.StartGoroutines(func() {
+		errc <- this.pipe(s, conn, backend, &s.down, &this.stats.BytesDown)
+	})
+	err1 := <-errc
+	err2 := <-errc
+
+	conn.Close()
+	backend.Close()
+	this.mu.Lock()
+	delete(this.sessions, s)
+	this.mu.Unlock()
+	atomic.AddInt64(&this.stats.Active, -1)
+
+	if this.opts.OnClose != nil {
+		err = err1
+		if err == nil {
+			err = err2
+		}
+		if s.idle {
+			err = ErrIdleTimeout
+		}
+		this.opts.OnClose(RawSessionStats{
+			Client:    conn.RemoteAddr(),
+			Backend:   backend.RemoteAddr(),
+			BytesUp:   atomic.LoadInt64(&s.up),
+			BytesDown: atomic.LoadInt64(&s.down),
+			Duration:  time.Since(start),
+			Err:       err,
+		})
+	}
+}
+
+// 单向拷贝 src -> dst	src 读完后半关闭 dst 的写方向
+func (this *rawproxy) pipe(s *rawSession, dst, src net.Conn, counter, total *int64) error {
+	buf := make([]byte, RAW_BUFFER_SIZE)
+	idle := this.opts.IdleTimeout
+	for {
+		if idle > 0 {
+			src.SetReadDeadline(time.Now().Add(idle))
+		}
+		n, err := src.Read(buf)
+		if n > 0 {
+			atomic.StoreInt64(&s.last, time.Now().UnixNano())
+			if _, werr := dst.Write(buf[:n]); werr != nil {
+				s.abort()
+				return werr
+			}
+			atomic.AddInt64(counter, int64(n))
+			atomic.AddInt64(total, int64(n))
+		}
+		if err == nil {
+			continue
+		}
+		if ne, ok := err.(net.Error); ok && ne.Timeout() {
+			//	另一个方向仍有数据	继续等待
+			last := time.Unix(0, atomic.LoadInt64(&s.last))
+			if time.Since(last) < idle {
+				continue
+			}
+			s.idle_once.Do(func() {
+				s.idle = true
+				s.abort()
+			})
+			return ErrIdleTimeout
+		}
+		if err == io.EOF {
+			closeWrite(dst)
+			return nil
+		}
+		s.abort()
+		return err
+	}
+}
+
+// 关闭会话两端	另一个方向的pipe随之退出	Serve不会一直等到空闲超时
+func (this *rawSession) abort() {
+	this.client.Close()
+	this.backend.Close()
+}
+
+func closeWrite(conn net.Conn) {
+	if c, ok := conn.(interface {
+		CloseWrite() error
+	}); ok {
+		c.CloseWrite()
+		return
+	}
+	conn.Close()
+}
+
+func (this *rawproxy) Stats() RawStats {
+	return RawStats{
+		Sessions:  atomic.LoadInt64(&this.stats.Sessions),
+		Active:    atomic.LoadInt64(&this.stats.Active),
+		Failed:    atomic.LoadInt64(&this.stats.Failed),
+		BytesUp:   atomic.LoadInt64(&this.stats.BytesUp),
+		BytesDown: atomic.LoadInt64(&this.stats.BytesDown),
+	}
+}
+
+func (this *rawproxy) Close() {
+	this.mu.Lock()
+	this.closed = true
+	sessions := this.sessions
+	this.sessions = make(map[*rawSession]struct{})
+	this.mu.Unlock()
+	for s := range sessions {
+		s.abort()
+	}
+}
+
+func NewRawProxy(remote string, opts RawOptions) RawProxyHandle {
+	if opts.IdleTimeout == 0 {
+		opts.IdleTimeout = DEFAULT_RAW_IDLE_TIMEOUT
+	}
+	return &rawproxy{
+		opts:     opts,
+		remote:   remote,
+		sessions: make(map[*rawSession]struct{}),
+	}
+}
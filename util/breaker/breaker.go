@@ -0,0 +1,315 @@
+package breaker
+
+import (
+	"sync"
+	"time"
+	"wwt/ctrl"
+)
+
+// 熔断器状态
+type State int
+
+const (
+	CLOSED    State = iota //	正常	请求全部放行
+	OPEN                   //	熔断	请求全部拒绝	冷却结束后进入HALF_OPEN
+	HALF_OPEN              //	半开	放行少量探测请求	成功后恢复CLOSED	失败后重新OPEN
+)
+
+func (this State) String() string {
+	switch this {
+	case CLOSED:
+		return "closed"
+	case OPEN:
+		return "open"
+	case HALF_OPEN:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+const (
+	DEFAULT_FAILURE_THRESHOLD = 5
+	DEFAULT_COOLDOWN          = 10 * time.Second
+	DEFAULT_HALF_OPEN_MAX     = 1
+	DEFAULT_WINDOW            = 10 * time.Second
+	DEFAULT_MIN_REQUESTS      = 20
+)
+
+type StateChangeFunc func(name string, from State, to State)
+
+// 熔断配置	零值字段使用默认值
+type Options struct {
+	FailureThreshold int           //	连续失败多少次后熔断	<0 表示不按连续失败熔断
+	Cooldown         time.Duration //	熔断后多久进入半开状态
+	HalfOpenMax      int           //	半开状态下同时放行的探测请求数
+	SuccessThreshold int           //	半开状态下连续成功多少次后恢复
+
+	//	异常检测	统计窗口内请求数达到MinRequests后	错误率或超时率超过阈值时熔断
+	Window      time.Duration
+	MinRequests int
+	ErrorRate   float64 //	<=0 表示不检测
+	TimeoutRate float64 //	<=0 表示不检测
+
+	OnStateChange StateChangeFunc
+}
+
+func (this *Options) normalize() {
+	if this.FailureThreshold == 0 {
+		this.FailureThreshold = DEFAULT_FAILURE_THRESHOLD
+	}
+	if this.Cooldown <= 0 {
+		this.Cooldown = DEFAULT_COOLDOWN
+	}
+	if this.HalfOpenMax <= 0 {
+		this.HalfOpenMax = DEFAULT_HALF_OPEN_MAX
+	}
+	if this.SuccessThreshold <= 0 {
+		this.SuccessThreshold = 1
+	}
+	if this.Window <= 0 {
+		this.Window = DEFAULT_WINDOW
+	}
+	if this.MinRequests <= 0 {
+		this.MinRequests = DEFAULT_MIN_REQUESTS
+	}
+}
+
+// 统计窗口内的请求情况
+type Stats struct {
+	State    State
+	Requests int
+	Errors   int
+	Timeouts int
+}
+
+type Breaker struct {
+	name string
+	opts Options
+
+	mu        sync.Mutex
+	state     State
+	fails     int //	连续失败次数
+	successes int //	半开状态下连续成功次数
+	probing   int //	半开状态下未完成的探测请求
+	opened_at time.Time
+
+	window_start time.Time
+	requests     int
+	errors       int
+	timeouts     int
+
+	events     []stateChange //	等待投递的状态变化	按发生顺序
+	delivering bool          //	是否有goroutine正在投递
+}
+
+type stateChange struct {
+	from State
+	to   State
+}
+
+// 调用者持有锁
+func (this *Breaker) setState(to State, now time.Time) {
+	from := this.state
+	if from == to {
+		return
+	}
+	this.state = to
+	this.fails = 0
+	this.successes = 0
+	this.probing = 0
+	if to == OPEN {
+		this.opened_at = now
+	}
+	if to == CLOSED {
+		this.resetWindow(now)
+	}
+	if this.opts.OnStateChange != nil {
+		//	回调中可能再次访问熔断器	放到锁外执行
+		//	由同一个goroutine依次投递	回调看到的顺序与状态变化的顺序一致
+		this.events = append(this.events, stateChange{from, to})
+		if !this.delivering {
+			this.delivering = true
+			ctrl.StartGoroutines(this.deliver)
+		}
+	}
+}
+
+// 依次投递状态变化	队列为空时退出
+func (this *Breaker) deliver() {
+	this.mu.Lock()
+	for len(this.events) > 0 {
+		ev := this.events[0]
+		this.events = this.events[1:]
+		this.mu.Unlock()
+		this.opts.OnStateChange(this.name, ev.from, ev.to)
+		this.mu.Lock()
+	}
+	this.events = nil
+	this.delivering = false
+	this.mu.Unlock()
+}
+
+func (this *Breaker) resetWindow(now time.Time) {
+	this.window_start = now
+	this.requests = 0
+	this.errors = 0
+	this.timeouts = 0
+}
+
+// 冷却结束时从OPEN进入HALF_OPEN	调用者持有锁
+func (this *Breaker) refresh(now time.Time) {
+	if this.state == OPEN && now.Sub(this.opened_at) >= this.opts.Cooldown {
+		this.setState(HALF_OPEN, now)
+	}
+	if now.Sub(this.window_start) >= this.opts.Window {
+		this.resetWindow(now)
+	}
+}
+
+// 是否可以发起请求	不占用半开状态的探测名额
+func (this *Breaker) Ready() bool {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	this.refresh(time.Now())
+	switch this.state {
+	case CLOSED:
+		return true
+	case HALF_OPEN:
+		return this.probing < this.opts.HalfOpenMax
+	}
+	return false
+}
+
+// 申请发起一次请求	半开状态下占用一个探测名额	之后必须调用Success/Failure/Timeout之一
+func (this *Breaker) Allow() bool {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	this.refresh(time.Now())
+	switch this.state {
+	case CLOSED:
+		return true
+	case HALF_OPEN:
+		if this.probing < this.opts.HalfOpenMax {
+			this.probing++
+			return true
+		}
+	}
+	return false
+}
+
+func (this *Breaker) Success() {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	now := time.Now()
+	this.refresh(now)
+	this.requests++
+	this.fails = 0
+	if this.state == HALF_OPEN {
+		if this.probing > 0 {
+			this.probing--
+		}
+		this.successes++
+		if this.successes >= this.opts.SuccessThreshold {
+			this.setState(CLOSED, now)
+		}
+	}
+}
+
+// 申请的请求没有结果	例如调用方自己取消	归还半开状态的探测名额	不计入统计
+func (this *Breaker) Release() {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	if this.state == HALF_OPEN && this.probing > 0 {
+		this.probing--
+	}
+}
+
+func (this *Breaker) Failure() {
+	this.record(false)
+}
+
+func (this *Breaker) Timeout() {
+	this.record(true)
+}
+
+func (this *Breaker) record(timeout bool) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	now := time.Now()
+	this.refresh(now)
+	this.requests++
+	if timeout {
+		this.timeouts++
+	} else {
+		this.errors++
+	}
+	switch this.state {
+	case HALF_OPEN:
+		//	探测失败	重新熔断
+		this.setState(OPEN, now)
+	case CLOSED:
+		this.fails++
+		if this.opts.FailureThreshold > 0 && this.fails >= this.opts.FailureThreshold {
+			this.setState(OPEN, now)
+			return
+		}
+		if this.outlier() {
+			this.setState(OPEN, now)
+		}
+	}
+}
+
+// 错误率或超时率超过阈值	调用者持有锁
+func (this *Breaker) outlier() bool {
+	if this.requests < this.opts.MinRequests {
+		return false
+	}
+	total := float64(this.requests)
+	if this.opts.ErrorRate > 0 && float64(this.errors+this.timeouts)/total >= this.opts.ErrorRate {
+		return true
+	}
+	if this.opts.TimeoutRate > 0 && float64(this.timeouts)/total >= this.opts.TimeoutRate {
+		return true
+	}
+	return false
+}
+
+// 立即熔断	例如确认后端已经宕机
+func (this *Breaker) Trip() {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	now := time.Now()
+	this.setState(OPEN, now)
+	this.opened_at = now
+}
+
+// 立即恢复
+func (this *Breaker) Reset() {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	this.setState(CLOSED, time.Now())
+}
+
+func (this *Breaker) State() State {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	this.refresh(time.Now())
+	return this.state
+}
+
+func (this *Breaker) Stats() Stats {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	this.refresh(time.Now())
+	return Stats{this.state, this.requests, this.errors, this.timeouts}
+}
+
+func (this *Breaker) Name() string {
+	return this.name
+}
+
+func New(name string, opts Options) *Breaker {
+	opts.normalize()
+	now := time.Now()
+	return &Breaker{name: name, opts: opts, state: CLOSED, window_start: now}
+}
@@ -3,6 +3,7 @@ package util
 import (
 	"math"
 	"errors"
+	"fmt"
 )
 
 func bytesToUint16(p []byte) uint16 {
@@ -301,6 +302,15 @@ func (this *stream) Reset() {
 	//this.off = 0
 }
 
+// 读取长度前缀	长度为负或超过剩余数据时panic	分配内存前校验	对端不能用伪造的长度触发巨大的分配
+func ReadLen(stream StreamBuffer) int {
+	n := stream.ReadInt()
+	if n < 0 || n > stream.Len() {
+		panic(fmt.Errorf("Invalid length %d, %d bytes left.", n, stream.Len()))
+	}
+	return n
+}
+
 func NewStreamBuffer() StreamBuffer {
 	return &stream{make([]byte, 0), 0, 0, 0}
 }
@@ -1,91 +1,67 @@
 package timertick
 
 import (
-	"wwt/util/heap"
-	"wwt/ctrl"
+	"sync"
 	"time"
-	"fmt"
 )
 
 const (
-	TIMERFUNC_COUNT = 8
+	TIMERFUNC_COUNT = 8 //	执行回调的goroutine数量
 )
 
 type TickFunc func()
 
-type TaskPackage struct {
-	F         TickFunc
-	TimeStamp int64
-}
-
-func (this *TaskPackage) Compare(other heap.Compareable) bool {
-	t := other.(*TaskPackage)
-	return this.TimeStamp < t.TimeStamp
-}
-
-var ch chan struct{}
-
-var taskList *heap.SafeHeap
+var wheel *Wheel
+var wheel_mu sync.Mutex
 
+// 启动全局时间轮	已启动时不做任何事
 func StartTimerTick() {
-	ch = make(chan struct{})
-	taskList = heap.NewSafeHeap()
-
-	for i := 0; i < TIMERFUNC_COUNT; i++ {
-		ctrl.StartGoroutines(func() {
-			timerFunc()
-		})
+	wheel_mu.Lock()
+	defer wheel_mu.Unlock()
+	if wheel == nil {
+		wheel = NewWheel(TIMERFUNC_COUNT)
+		wheel.Start()
 	}
 }
 
-func timerFunc() {
-	defer func() {
-		fmt.Println("Timer Func Exit.")
-	}()
-	for {
-		time.Sleep(time.Second)
-		select {
-		case <-ch:
-			return
-		default:
-			var task *TaskPackage = nil
-			taskList.Lock()
-			//	取任务
-			if taskList.Len() > 0 {
-				task = taskList.Pop().(*TaskPackage)
-			}
-			taskList.UnLock()
-
-			if task != nil {
-				cur := time.Now().UnixNano() / 1e6
-				if task.TimeStamp <= cur {
-					task.F()
-				} else {
-					taskList.Lock()
-					taskList.AddNode(task)
-					taskList.UnLock()
-				}
-			}
-		}
+func global() *Wheel {
+	wheel_mu.Lock()
+	w := wheel
+	wheel_mu.Unlock()
+	if w == nil {
+		StartTimerTick()
+		return global()
 	}
+	return w
 }
 
+// 在tickTime(Unix毫秒时间戳)执行tickFunc	返回的句柄可以取消
+func AddTask(tickTime int64, tickFunc TickFunc) *Timer {
+	return global().AtFunc(tickTime, tickFunc)
+}
 
-func AddTask(tickTime int64, tickFunc TickFunc) {
-	task := new(TaskPackage)
-	task.F = tickFunc
-	task.TimeStamp = tickTime
+// delay之后执行一次
+func AddTimer(delay time.Duration, tickFunc TickFunc) *Timer {
+	return global().AfterFunc(delay, tickFunc)
+}
+
+// 每隔interval执行一次	直到取消
+func AddRepeat(interval time.Duration, tickFunc TickFunc) *Timer {
+	return global().Every(interval, tickFunc)
+}
 
-	taskList.Lock()
-	taskList.AddNode(task)
-	taskList.UnLock()
+// 等待触发的定时器数量
+func Len() int {
+	return global().Len()
 }
 
+// 停止全局时间轮	未到期的定时器被丢弃
 func CloseTimerTick() {
-	close(ch)
-	taskList.Lock()
-	for taskList.Len() > 0 {
-		taskList.Pop()
+	wheel_mu.Lock()
+	w := wheel
+	wheel = nil
+	wheel_mu.Unlock()
+	if w != nil {
+		w.Stop()
 	}
-	taskList.UnLock()
 }
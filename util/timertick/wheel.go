@@ -0,0 +1,352 @@
+package timertick
+
+import (
+	"container/list"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+	"time"
+	"wwt/ctrl"
+)
+
+// 分层时间轮	参照Linux内核的定时器实现
+// 第0层256个槽	每槽1ms	其余4层各64个槽	每层一个槽覆盖下一层的一整圈
+// 插入与取消都是O(1)	到期时上层的槽整体下沉到下层
+const (
+	TICK       = time.Millisecond
+	WHEEL_BITS = 8 //	第0层
+	LEVEL_BITS = 6 //	第1~4层
+	LEVELS     = 5
+
+	WHEEL_SIZE = 1 << WHEEL_BITS
+	WHEEL_MASK = WHEEL_SIZE - 1
+	LEVEL_SIZE = 1 << LEVEL_BITS
+	LEVEL_MASK = LEVEL_SIZE - 1
+
+	//	能直接放入时间轮的最大间隔	约49天	更远的定时器放在最高层	下沉时重新计算
+	MAX_TIMEOUT = int64(1)<<(WHEEL_BITS+(LEVELS-1)*LEVEL_BITS) - 1
+
+	JOB_QUEUE_SIZE = 4096
+)
+
+const (
+	timer_pending int32 = iota
+	timer_fired
+	timer_cancelled
+)
+
+// AddTask等返回的定时器句柄
+type Timer struct {
+	expires  int64 //	到期的tick
+	interval int64 //	重复间隔	0表示只执行一次
+	f        TickFunc
+
+	wheel *Wheel
+	slot  *list.List
+	elem  *list.Element
+	state int32
+}
+
+// 取消定时器	返回false表示已执行或已取消
+// 重复定时器在回调执行期间取消时	本次回调仍会完成	之后不再触发
+func (this *Timer) Cancel() bool {
+	if this == nil {
+		return false
+	}
+	w := this.wheel
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.stopped {
+		//	时间轮已停止	槽位已被重置	不能再从中移除
+		return false
+	}
+	if !atomic.CompareAndSwapInt32(&this.state, timer_pending, timer_cancelled) {
+		return false
+	}
+	if this.elem != nil {
+		this.slot.Remove(this.elem)
+		this.slot, this.elem = nil, nil
+		w.count--
+	}
+	return true
+}
+
+// 是否还会触发
+func (this *Timer) Pending() bool {
+	return this != nil && atomic.LoadInt32(&this.state) == timer_pending
+}
+
+type Wheel struct {
+	mu      sync.Mutex
+	levels  [LEVELS][]*list.List
+	current int64 //	下一个要处理的tick
+	count   int
+	start   time.Time
+	stopped bool //	Stop之后槽位已清空	不再增删定时器
+
+	expired []*Timer
+	jobs    chan *Timer
+	workers int
+
+	exit      chan struct{}
+	done      sync.WaitGroup
+	stop_once sync.Once
+}
+
+func NewWheel(workers int) *Wheel {
+	if workers <= 0 {
+		workers = TIMERFUNC_COUNT
+	}
+	w := &Wheel{
+		start:   time.Now(),
+		jobs:    make(chan *Timer, JOB_QUEUE_SIZE),
+		workers: workers,
+		exit:    make(chan struct{}),
+	}
+	for lvl := 0; lvl < LEVELS; lvl++ {
+		size := LEVEL_SIZE
+		if lvl == 0 {
+			size = WHEEL_SIZE
+		}
+		w.levels[lvl] = make([]*list.List, size)
+		for i := range w.levels[lvl] {
+			w.levels[lvl][i] = list.New()
+		}
+	}
+	return w
+}
+
+// 当前的tick	即启动以来的毫秒数
+func (this *Wheel) now() int64 {
+	return int64(time.Since(this.start) / TICK)
+}
+
+// 第lvl层(lvl>=1)中expires所在的槽
+func levelIndex(lvl int, expires int64) int {
+	return int((expires >> uint(WHEEL_BITS+(lvl-1)*LEVEL_BITS)) & LEVEL_MASK)
+}
+
+// 放入对应的槽	调用者持有锁
+func (this *Wheel) add(t *Timer) {
+	delta := t.expires - this.current
+	var slot *list.List
+	switch {
+	case delta < 0:
+		//	已经过期	下一个tick执行
+		slot = this.levels[0][this.current&WHEEL_MASK]
+	case delta < WHEEL_SIZE:
+		slot = this.levels[0][t.expires&WHEEL_MASK]
+	default:
+		expires := t.expires
+		if delta > MAX_TIMEOUT {
+			expires = this.current + MAX_TIMEOUT
+			delta = MAX_TIMEOUT
+		}
+		lvl := 1
+		for lvl < LEVELS-1 && delta >= int64(1)<<uint(WHEEL_BITS+lvl*LEVEL_BITS) {
+			lvl++
+		}
+		slot = this.levels[lvl][levelIndex(lvl, expires)]
+	}
+	t.slot = slot
+	t.elem = slot.PushBack(t)
+	this.count++
+}
+
+// 把上层一个槽中的定时器重新放入下层	调用者持有锁
+func (this *Wheel) cascade(lvl int, idx int) {
+	slot := this.levels[lvl][idx]
+	for e := slot.Front(); e != nil; {
+		next := e.Next()
+		t := slot.Remove(e).(*Timer)
+		this.count--
+		this.add(t)
+		e = next
+	}
+}
+
+// 处理当前tick	返回到期的定时器	调用者持有锁
+func (this *Wheel) tick() []*Timer {
+	expired := this.expired[:0]
+	idx := int(this.current & WHEEL_MASK)
+	if idx == 0 {
+		for lvl := 1; lvl < LEVELS; lvl++ {
+			i := levelIndex(lvl, this.current)
+			this.cascade(lvl, i)
+			if i != 0 {
+				break
+			}
+		}
+	}
+	slot := this.levels[0][idx]
+	for e := slot.Front(); e != nil; {
+		next := e.Next()
+		t := slot.Remove(e).(*Timer)
+		t.slot, t.elem = nil, nil
+		this.count--
+		if t.interval > 0 && atomic.LoadInt32(&t.state) == timer_pending {
+			//	以计划时间为基准	避免误差累积
+			t.expires += t.interval
+			if t.expires <= this.current {
+				t.expires = this.current + 1
+			}
+			this.add(t)
+		}
+		expired = append(expired, t)
+		e = next
+	}
+	this.current++
+	this.expired = expired
+	return expired
+}
+
+// 处理到now为止的所有tick
+func (this *Wheel) advance(now int64) {
+	for {
+		this.mu.Lock()
+		if this.current > now {
+			this.mu.Unlock()
+			return
+		}
+		expired := this.tick()
+		if len(expired) == 0 {
+			this.mu.Unlock()
+			continue
+		}
+		jobs := make([]*Timer, len(expired))
+		copy(jobs, expired)
+		this.mu.Unlock()
+		for _, t := range jobs {
+			select {
+			case this.jobs <- t:
+			case <-this.exit:
+				return
+			}
+		}
+	}
+}
+
+func (this *Wheel) run() {
+	defer this.done.Done()
+	ticker := time.NewTicker(TICK)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-this.exit:
+			return
+		case <-ticker.C:
+			this.advance(this.now())
+		}
+	}
+}
+
+func (this *Wheel) work() {
+	defer this.done.Done()
+	for {
+		select {
+		case <-this.exit:
+			return
+		case t := <-this.jobs:
+			this.fire(t)
+		}
+	}
+}
+
+func (this *Wheel) fire(t *Timer) {
+	if t.interval > 0 {
+		if atomic.LoadInt32(&t.state) != timer_pending {
+			return
+		}
+	} else if !atomic.CompareAndSwapInt32(&t.state, timer_pending, timer_fired) {
+		return
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			ctrl.ReportPanic("timertick", r, debug.Stack())
+		}
+	}()
+	t.f()
+}
+
+// 启动驱动时间轮的goroutine与执行回调的goroutine
+func (this *Wheel) Start() {
+	this.done.Add(1 + this.workers)
+	ctrl.StartGoroutines(func() {
+		this.run()
+	})
+	for i := 0; i < this.workers; i++ {
+		ctrl.StartGoroutines(func() {
+			this.work()
+		})
+	}
+}
+
+// 停止时间轮	未到期的定时器被丢弃	正在执行的回调不会被中断
+func (this *Wheel) Stop() {
+	this.stop_once.Do(func() {
+		close(this.exit)
+		this.done.Wait()
+		this.mu.Lock()
+		this.stopped = true
+		for lvl := range this.levels {
+			for _, slot := range this.levels[lvl] {
+				slot.Init()
+			}
+		}
+		this.count = 0
+		this.mu.Unlock()
+	})
+}
+
+func (this *Wheel) schedule(delay time.Duration, interval time.Duration, f TickFunc) *Timer {
+	d := int64((delay + TICK - 1) / TICK)
+	if d < 0 {
+		d = 0
+	}
+	t := &Timer{f: f, wheel: this, interval: int64(interval / TICK)}
+	if interval > 0 && t.interval == 0 {
+		t.interval = 1
+	}
+	this.mu.Lock()
+	if this.stopped {
+		//	停止后添加的定时器不会触发
+		this.mu.Unlock()
+		t.state = timer_cancelled
+		return t
+	}
+	//	以当前时间计算到期tick	驱动落后时也不会提前触发
+	//	now向下取整	当前tick已经过去了一部分	从下一个tick起算d个tick	保证不早于delay触发
+	now := this.now()
+	if d > 0 {
+		now++
+	}
+	if now < this.current {
+		now = this.current
+	}
+	t.expires = now + d
+	this.add(t)
+	this.mu.Unlock()
+	return t
+}
+
+// delay之后执行一次
+func (this *Wheel) AfterFunc(delay time.Duration, f TickFunc) *Timer {
+	return this.schedule(delay, 0, f)
+}
+
+// 在at(Unix毫秒时间戳)执行一次	已过期时尽快执行
+func (this *Wheel) AtFunc(at int64, f TickFunc) *Timer {
+	return this.schedule(time.Duration(at-time.Now().UnixNano()/1e6)*time.Millisecond, 0, f)
+}
+
+// 每隔interval执行一次	第一次在interval之后	回调耗时超过interval时可能并发执行
+func (this *Wheel) Every(interval time.Duration, f TickFunc) *Timer {
+	return this.schedule(interval, interval, f)
+}
+
+// 等待触发的定时器数量
+func (this *Wheel) Len() int {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	return this.count
+}
@@ -0,0 +1,376 @@
+package tlv
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// 字段格式	与protobuf的wire format相同
+// | key(varint: tag<<3 | wire) | value |
+// varint	有符号整数使用zigzag编码
+// fixed32/fixed64	小端
+// bytes	varint长度 + 数据	字符串、字节数组、嵌套消息
+// 重复字段即同一个tag出现多次	解码时忽略未知的tag
+type WireType byte
+
+const (
+	WIRE_VARINT  WireType = 0
+	WIRE_FIXED64 WireType = 1
+	WIRE_BYTES   WireType = 2
+	WIRE_FIXED32 WireType = 5
+
+	MAX_TAG = 1<<29 - 1
+)
+
+var ErrTruncated = errors.New("tlv: truncated data")
+var ErrInvalidTag = errors.New("tlv: invalid tag")
+
+// 可以编码为TLV的消息
+type Marshaler interface {
+	MarshalTLV(e *Encoder)
+}
+
+// 可以从TLV解码的消息	缺失的字段应取默认值
+type Unmarshaler interface {
+	UnmarshalTLV(d *Decoder) error
+}
+
+func zigzag(v int64) uint64 {
+	return uint64(v<<1) ^ uint64(v>>63)
+}
+
+func unzigzag(v uint64) int64 {
+	return int64(v>>1) ^ -int64(v&1)
+}
+
+type Encoder struct {
+	buf []byte
+}
+
+func (this *Encoder) key(tag int, wire WireType) {
+	if tag <= 0 || tag > MAX_TAG {
+		panic(ErrInvalidTag)
+	}
+	this.varint(uint64(tag)<<3 | uint64(wire))
+}
+
+func (this *Encoder) varint(v uint64) {
+	var b [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(b[:], v)
+	this.buf = append(this.buf, b[:n]...)
+}
+
+func (this *Encoder) WriteInt(tag int, v int64) {
+	this.key(tag, WIRE_VARINT)
+	this.varint(zigzag(v))
+}
+
+func (this *Encoder) WriteUint(tag int, v uint64) {
+	this.key(tag, WIRE_VARINT)
+	this.varint(v)
+}
+
+func (this *Encoder) WriteBool(tag int, v bool) {
+	if v {
+		this.WriteUint(tag, 1)
+	} else {
+		this.WriteUint(tag, 0)
+	}
+}
+
+func (this *Encoder) WriteFloat32(tag int, v float32) {
+	this.key(tag, WIRE_FIXED32)
+	var b [4]byte
+	binary.LittleEndian.PutUint32(b[:], math.Float32bits(v))
+	this.buf = append(this.buf, b[:]...)
+}
+
+func (this *Encoder) WriteFloat64(tag int, v float64) {
+	this.key(tag, WIRE_FIXED64)
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], math.Float64bits(v))
+	this.buf = append(this.buf, b[:]...)
+}
+
+func (this *Encoder) WriteBytes(tag int, v []byte) {
+	this.key(tag, WIRE_BYTES)
+	this.varint(uint64(len(v)))
+	this.buf = append(this.buf, v...)
+}
+
+func (this *Encoder) WriteString(tag int, v string) {
+	this.WriteBytes(tag, []byte(v))
+}
+
+// 写入嵌套消息
+func (this *Encoder) WriteMessage(tag int, m Marshaler) {
+	this.WriteBytes(tag, Marshal(m))
+}
+
+// 重复字段
+func (this *Encoder) WriteInts(tag int, vs []int64) {
+	for _, v := range vs {
+		this.WriteInt(tag, v)
+	}
+}
+
+func (this *Encoder) WriteStrings(tag int, vs []string) {
+	for _, v := range vs {
+		this.WriteString(tag, v)
+	}
+}
+
+func (this *Encoder) Bytes() []byte {
+	return this.buf
+}
+
+func (this *Encoder) Len() int {
+	return len(this.buf)
+}
+
+func (this *Encoder) Reset() {
+	this.buf = this.buf[:0]
+}
+
+func NewEncoder() *Encoder {
+	return &Encoder{make([]byte, 0, 64)}
+}
+
+type value struct {
+	wire WireType
+	num  uint64 //	varint/fixed32/fixed64
+	data []byte //	bytes
+}
+
+// 解码后的字段集合	按tag读取	读取时给出默认值
+// 同一tag出现多次时	单值读取取最后一个	重复字段读取全部
+type Decoder struct {
+	fields map[int][]value
+}
+
+func NewDecoder(b []byte) (*Decoder, error) {
+	d := &Decoder{make(map[int][]value)}
+	for off := 0; off < len(b); {
+		k, n := binary.Uvarint(b[off:])
+		if n <= 0 {
+			return nil, ErrTruncated
+		}
+		off += n
+		tag := int(k >> 3)
+		if tag <= 0 || k>>3 > MAX_TAG {
+			return nil, ErrInvalidTag
+		}
+		v := value{wire: WireType(k & 7)}
+		switch v.wire {
+		case WIRE_VARINT:
+			v.num, n = binary.Uvarint(b[off:])
+			if n <= 0 {
+				return nil, ErrTruncated
+			}
+			off += n
+		case WIRE_FIXED32:
+			if off+4 > len(b) {
+				return nil, ErrTruncated
+			}
+			v.num = uint64(binary.LittleEndian.Uint32(b[off:]))
+			off += 4
+		case WIRE_FIXED64:
+			if off+8 > len(b) {
+				return nil, ErrTruncated
+			}
+			v.num = binary.LittleEndian.Uint64(b[off:])
+			off += 8
+		case WIRE_BYTES:
+			l, n := binary.Uvarint(b[off:])
+			if n <= 0 || l > uint64(len(b)-off-n) {
+				return nil, ErrTruncated
+			}
+			off += n
+			v.data = b[off : off+int(l)]
+			off += int(l)
+		default:
+			return nil, fmt.Errorf("tlv: unknown wire type %d for tag %d", v.wire, tag)
+		}
+		d.fields[tag] = append(d.fields[tag], v)
+	}
+	return d, nil
+}
+
+func (this *Decoder) last(tag int, wire WireType) (value, bool) {
+	vs := this.fields[tag]
+	for i := len(vs) - 1; i >= 0; i-- {
+		if vs[i].wire == wire {
+			return vs[i], true
+		}
+	}
+	return value{}, false
+}
+
+func (this *Decoder) all(tag int, wire WireType) []value {
+	var res []value
+	for _, v := range this.fields[tag] {
+		if v.wire == wire {
+			res = append(res, v)
+		}
+	}
+	return res
+}
+
+// 字段是否存在
+func (this *Decoder) Has(tag int) bool {
+	return len(this.fields[tag]) > 0
+}
+
+// 出现过的所有tag	用于调试或转发未知字段
+func (this *Decoder) Tags() []int {
+	tags := make([]int, 0, len(this.fields))
+	for t := range this.fields {
+		tags = append(tags, t)
+	}
+	return tags
+}
+
+func (this *Decoder) Int(tag int, def int64) int64 {
+	if v, ok := this.last(tag, WIRE_VARINT); ok {
+		return unzigzag(v.num)
+	}
+	return def
+}
+
+func (this *Decoder) Uint(tag int, def uint64) uint64 {
+	if v, ok := this.last(tag, WIRE_VARINT); ok {
+		return v.num
+	}
+	return def
+}
+
+func (this *Decoder) Bool(tag int, def bool) bool {
+	if v, ok := this.last(tag, WIRE_VARINT); ok {
+		return v.num != 0
+	}
+	return def
+}
+
+func (this *Decoder) Float32(tag int, def float32) float32 {
+	if v, ok := this.last(tag, WIRE_FIXED32); ok {
+		return math.Float32frombits(uint32(v.num))
+	}
+	return def
+}
+
+func (this *Decoder) Float64(tag int, def float64) float64 {
+	if v, ok := this.last(tag, WIRE_FIXED64); ok {
+		return math.Float64frombits(v.num)
+	}
+	return def
+}
+
+func (this *Decoder) Bytes(tag int, def []byte) []byte {
+	if v, ok := this.last(tag, WIRE_BYTES); ok {
+		res := make([]byte, len(v.data))
+		copy(res, v.data)
+		return res
+	}
+	return def
+}
+
+func (this *Decoder) String(tag int, def string) string {
+	if v, ok := this.last(tag, WIRE_BYTES); ok {
+		return string(v.data)
+	}
+	return def
+}
+
+// 读取嵌套消息	字段不存在时返回false且不修改m
+func (this *Decoder) Message(tag int, m Unmarshaler) (bool, error) {
+	v, ok := this.last(tag, WIRE_BYTES)
+	if !ok {
+		return false, nil
+	}
+	return true, Unmarshal(v.data, m)
+}
+
+func (this *Decoder) Ints(tag int) []int64 {
+	vs := this.all(tag, WIRE_VARINT)
+	res := make([]int64, len(vs))
+	for i, v := range vs {
+		res[i] = unzigzag(v.num)
+	}
+	return res
+}
+
+func (this *Decoder) Uints(tag int) []uint64 {
+	vs := this.all(tag, WIRE_VARINT)
+	res := make([]uint64, len(vs))
+	for i, v := range vs {
+		res[i] = v.num
+	}
+	return res
+}
+
+func (this *Decoder) Float32s(tag int) []float32 {
+	vs := this.all(tag, WIRE_FIXED32)
+	res := make([]float32, len(vs))
+	for i, v := range vs {
+		res[i] = math.Float32frombits(uint32(v.num))
+	}
+	return res
+}
+
+func (this *Decoder) Float64s(tag int) []float64 {
+	vs := this.all(tag, WIRE_FIXED64)
+	res := make([]float64, len(vs))
+	for i, v := range vs {
+		res[i] = math.Float64frombits(v.num)
+	}
+	return res
+}
+
+func (this *Decoder) Strings(tag int) []string {
+	vs := this.all(tag, WIRE_BYTES)
+	res := make([]string, len(vs))
+	for i, v := range vs {
+		res[i] = string(v.data)
+	}
+	return res
+}
+
+func (this *Decoder) BytesList(tag int) [][]byte {
+	vs := this.all(tag, WIRE_BYTES)
+	res := make([][]byte, len(vs))
+	for i, v := range vs {
+		res[i] = make([]byte, len(v.data))
+		copy(res[i], v.data)
+	}
+	return res
+}
+
+// 读取重复的嵌套消息	每个元素调用一次newFunc创建
+func (this *Decoder) Messages(tag int, newFunc func() Unmarshaler) ([]Unmarshaler, error) {
+	vs := this.all(tag, WIRE_BYTES)
+	res := make([]Unmarshaler, 0, len(vs))
+	for _, v := range vs {
+		m := newFunc()
+		if err := Unmarshal(v.data, m); err != nil {
+			return nil, err
+		}
+		res = append(res, m)
+	}
+	return res, nil
+}
+
+func Marshal(m Marshaler) []byte {
+	e := NewEncoder()
+	m.MarshalTLV(e)
+	return e.Bytes()
+}
+
+func Unmarshal(b []byte, m Unmarshaler) error {
+	d, err := NewDecoder(b)
+	if err != nil {
+		return err
+	}
+	return m.UnmarshalTLV(d)
+}